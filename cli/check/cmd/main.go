@@ -4,8 +4,10 @@ import (
 	"encoding/json"
 	"github.com/samcontesse/gitlab-merge-request-resource/pkg"
 	"github.com/samcontesse/gitlab-merge-request-resource/pkg/check"
+	"github.com/samcontesse/gitlab-merge-request-resource/pkg/logging"
 	"github.com/xanzy/go-gitlab"
 	"os"
+	"time"
 )
 
 func main() {
@@ -13,7 +15,14 @@ func main() {
 	var request check.Request
 	inputRequest(&request)
 
-	client, err := gitlab.NewClient(request.Source.PrivateToken, gitlab.WithHTTPClient(pkg.GetDefaultClient(request.Source.Insecure)), gitlab.WithBaseURL(request.Source.GetBaseURL()))
+	logger, err := pkg.GetLogger(request.Source)
+	if err != nil {
+		pkg.Fatal("initializing logger", err)
+	}
+	logging.SetDefault(logger)
+	start := time.Now()
+
+	client, err := gitlab.NewClient(request.Source.PrivateToken, gitlab.WithHTTPClient(logger.Client(pkg.GetDefaultClient(request.Source.Insecure))), gitlab.WithBaseURL(request.Source.GetBaseURL()))
 	if err != nil {
 		pkg.Fatal("initializing gitlab client", err)
 	}
@@ -24,6 +33,7 @@ func main() {
 		pkg.Fatal("running command", err)
 	}
 
+	logger.Info("check finished", "versions", len(response), "duration", time.Since(start).Round(time.Millisecond))
 	outputResponse(response)
 }
 
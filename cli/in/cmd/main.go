@@ -4,8 +4,10 @@ import (
 	"encoding/json"
 	"github.com/samcontesse/gitlab-merge-request-resource/pkg"
 	"github.com/samcontesse/gitlab-merge-request-resource/pkg/in"
+	"github.com/samcontesse/gitlab-merge-request-resource/pkg/logging"
 	"github.com/xanzy/go-gitlab"
 	"os"
+	"time"
 )
 
 func main() {
@@ -20,17 +22,30 @@ func main() {
 	var request in.Request
 	inputRequest(&request)
 
-	client, err := gitlab.NewClient(request.Source.PrivateToken, gitlab.WithHTTPClient(pkg.GetDefaultClient(request.Source.Insecure)), gitlab.WithBaseURL(request.Source.GetBaseURL()))
+	logger, err := pkg.GetLogger(request.Source)
+	if err != nil {
+		pkg.Fatal("initializing logger", err)
+	}
+	logging.SetDefault(logger)
+	start := time.Now()
+
+	client, err := gitlab.NewClient(request.Source.PrivateToken, gitlab.WithHTTPClient(logger.Client(pkg.GetDefaultClient(request.Source.Insecure))), gitlab.WithBaseURL(request.Source.GetBaseURL()))
 	if err != nil {
 		pkg.Fatal("initializing gitlab client", err)
 	}
 
-	command := in.NewCommand(client)
+	runner, err := in.NewSourceRunner(request.Source)
+	if err != nil {
+		pkg.Fatal("initializing git runner", err)
+	}
+
+	command := in.NewCommand(client).WithRunner(runner)
 	response, err := command.Run(destination, request)
 	if err != nil {
 		pkg.Fatal("running command", err)
 	}
 
+	logger.Info("in finished", "version", response.Version.ID, "duration", time.Since(start).Round(time.Millisecond))
 	outputResponse(response)
 }
 
@@ -3,9 +3,11 @@ package main
 import (
 	"encoding/json"
 	"github.com/samcontesse/gitlab-merge-request-resource/pkg"
+	"github.com/samcontesse/gitlab-merge-request-resource/pkg/logging"
 	"github.com/samcontesse/gitlab-merge-request-resource/pkg/out"
 	"github.com/xanzy/go-gitlab"
 	"os"
+	"time"
 )
 
 func main() {
@@ -20,7 +22,16 @@ func main() {
 	var request out.Request
 	inputRequest(&request)
 
-	client, err := gitlab.NewClient(request.Source.PrivateToken, gitlab.WithHTTPClient(pkg.GetDefaultClient(request.Source.Insecure)), gitlab.WithBaseURL(request.Source.GetBaseURL()))
+	logger, err := pkg.GetLogger(request.Source)
+	if err != nil {
+		pkg.Fatal("initializing logger", err)
+	}
+	logging.SetDefault(logger)
+	start := time.Now()
+
+	httpClient := pkg.GetDefaultClient(request.Source.Insecure)
+
+	client, err := gitlab.NewClient(request.Source.PrivateToken, gitlab.WithHTTPClient(logger.Client(httpClient)), gitlab.WithBaseURL(request.Source.GetBaseURL()))
 	if err != nil {
 		pkg.Fatal("initializing gitlab client", err)
 	}
@@ -31,6 +42,7 @@ func main() {
 		pkg.Fatal("running command", err)
 	}
 
+	logger.Info("out finished", "version", response.Version.ID, "duration", time.Since(start).Round(time.Millisecond))
 	outputResponse(response)
 }
 
@@ -2,6 +2,7 @@ package check
 
 import (
 	"github.com/samcontesse/gitlab-merge-request-resource/pkg"
+	"github.com/samcontesse/gitlab-merge-request-resource/pkg/logging"
 	"github.com/xanzy/go-gitlab"
 	"strings"
 	"time"
@@ -9,14 +10,21 @@ import (
 
 type Command struct {
 	client *gitlab.Client
+	logger *logging.Logger
 }
 
 func NewCommand(client *gitlab.Client) *Command {
 	return &Command{
 		client: client,
+		logger: logging.Default(),
 	}
 }
 
+func (command *Command) WithLogger(logger *logging.Logger) *Command {
+	command.logger = logger
+	return command
+}
+
 func (command *Command) Run(request Request) (Response, error) {
 	labels := gitlab.Labels(request.Source.Labels)
 
@@ -26,6 +34,11 @@ func (command *Command) Run(request Request) (Response, error) {
 		return Response{}, err
 	}
 
+	_, err = request.Source.GetStatusProject()
+	if err != nil {
+		return Response{}, err
+	}
+
 	options := &gitlab.ListProjectMergeRequestsOptions{
 		State:        gitlab.String("opened"),
 		OrderBy:      gitlab.String("updated_at"),
@@ -40,10 +53,15 @@ func (command *Command) Run(request Request) (Response, error) {
 		return Response{}, err
 	}
 
+	command.logger.Debug("listed merge requests", "count", len(requests))
+
 	versions := make([]pkg.Version, 0)
 
 	for _, mr := range requests {
+		logger := command.logger.With("iid", mr.IID, "sha", mr.SHA)
+
 		if mr.SHA == "" {
+			logger.Debug("skipping merge request", "reason", "no commit")
 			continue
 		}
 
@@ -55,6 +73,7 @@ func (command *Command) Run(request Request) (Response, error) {
 		updatedAt := commit.CommittedDate
 
 		if strings.Contains(commit.Title, "[skip ci]") || strings.Contains(commit.Message, "[skip ci]") {
+			logger.Debug("skipping merge request", "reason", "[skip ci] in the commit message")
 			continue
 		}
 
@@ -64,14 +83,17 @@ func (command *Command) Run(request Request) (Response, error) {
 		}
 
 		if request.Source.SkipNotMergeable && mr.MergeStatus != "can_be_merged" {
+			logger.Debug("skipping merge request", "reason", "not mergeable", "merge_status", mr.MergeStatus)
 			continue
 		}
 
 		if request.Source.SkipWorkInProgress && mr.WorkInProgress {
+			logger.Debug("skipping merge request", "reason", "work in progress")
 			continue
 		}
 
 		if request.Version.UpdatedAt != nil && !updatedAt.After(*request.Version.UpdatedAt) {
+			logger.Debug("skipping merge request", "reason", "not updated since the current version", "updated_at", updatedAt)
 			continue
 		}
 
@@ -81,11 +103,12 @@ func (command *Command) Run(request Request) (Response, error) {
 		}
 
 		if !match {
+			logger.Debug("skipping merge request", "reason", "no change matching the paths")
 			continue
 		}
 
 		target := request.Source.GetTargetURL()
-		name := request.Source.GetPipelineName()
+		name := request.Source.GetStatusName()
 
 		options := gitlab.SetCommitStatusOptions{
 			Name:      &name,
@@ -93,8 +116,14 @@ func (command *Command) Run(request Request) (Response, error) {
 			State:     gitlab.Pending,
 		}
 
-		_, _, _ = command.client.Commits.SetCommitStatus(mr.SourceProjectID, mr.SHA, &options)
+		if !request.Source.DryRun && !request.Source.SkipPendingStatus {
+			err = pkg.SetCommitStatus(command.client, request.Source, mr, &options)
+			if err != nil {
+				logger.Warn("setting the pending status failed", "error", err)
+			}
+		}
 
+		logger.Info("found merge request", "updated_at", updatedAt)
 		versions = append(versions, pkg.Version{ID: mr.IID, UpdatedAt: updatedAt})
 
 	}
@@ -110,19 +139,12 @@ func matchPathPatterns(api *gitlab.Client, mr *gitlab.MergeRequest, source pkg.S
 
 	modified := 0
 
-	versions, _, err := api.MergeRequests.GetMergeRequestDiffVersions(mr.ProjectID, mr.IID, nil)
+	version, err := pkg.GetLatestDiffVersion(api, mr)
 	if err != nil {
 		return false, err
 	}
 
-	if len(versions) > 0 {
-
-		latest := versions[0].ID
-		version, _, err := api.MergeRequests.GetSingleMergeRequestDiffVersion(mr.ProjectID, mr.IID, latest)
-		if err != nil {
-			return false, err
-		}
-
+	if version != nil {
 		for _, d := range version.Diffs {
 			if source.AcceptPath(d.OldPath) || source.AcceptPath(d.NewPath) {
 				modified += 1
@@ -45,7 +45,23 @@ var _ = Describe("Check", func() {
 
 		Context("When it has a minimal valid configuration", func() {
 
+			var (
+				statuses int
+				name     string
+			)
+
 			BeforeEach(func() {
+				statuses, name = 0, ""
+				mux.HandleFunc("/api/v4/projects/42/statuses/abc", func(w http.ResponseWriter, r *http.Request) {
+					statuses++
+					var options gitlab.SetCommitStatusOptions
+					_ = json.NewDecoder(r.Body).Decode(&options)
+					name = *options.Name
+					w.Header().Set("content-type", "application/json")
+					w.WriteHeader(http.StatusCreated)
+					w.Write([]byte(`{}`))
+				})
+
 				mux.HandleFunc("/api/v4/projects/namespace/project/merge_requests", func(w http.ResponseWriter, r *http.Request) {
 					mr := gitlab.MergeRequest{IID: 88, ID: 99, SHA: "abc", ProjectID: 42}
 					output, _ := json.Marshal([]gitlab.MergeRequest{mr})
@@ -80,6 +96,83 @@ var _ = Describe("Check", func() {
 				Expect(len(response)).To(Equal(1))
 				Expect(response[0].ID).To(Equal(88))
 				Expect(response[0].UpdatedAt).To(Equal(&t))
+				Expect(statuses).To(Equal(1))
+				Expect(name).To(Equal("baltic"))
+			})
+
+			It("Should set the pending status under the source context", func() {
+
+				project, _ := url.Parse("namespace/project.git")
+				uri := root.ResolveReference(project)
+
+				request := check.Request{
+					Source: pkg.Source{
+						URI:          uri.String(),
+						PrivateToken: "$",
+						Context:      "$BUILD_PIPELINE_NAME/unit",
+					},
+				}
+
+				_, err := command.Run(request)
+				Expect(err).Should(BeNil())
+				Expect(statuses).To(Equal(1))
+				Expect(name).To(Equal("baltic/unit"))
+			})
+
+			It("Should not set the pending status when skipped", func() {
+
+				project, _ := url.Parse("namespace/project.git")
+				uri := root.ResolveReference(project)
+
+				request := check.Request{
+					Source: pkg.Source{
+						URI:               uri.String(),
+						PrivateToken:      "$",
+						SkipPendingStatus: true,
+					},
+				}
+
+				response, err := command.Run(request)
+				Expect(err).Should(BeNil())
+				Expect(len(response)).To(Equal(1))
+				Expect(statuses).To(BeZero())
+			})
+
+			It("Should not set the pending status in dry run", func() {
+
+				project, _ := url.Parse("namespace/project.git")
+				uri := root.ResolveReference(project)
+
+				request := check.Request{
+					Source: pkg.Source{
+						URI:          uri.String(),
+						PrivateToken: "$",
+						DryRun:       true,
+					},
+				}
+
+				response, err := command.Run(request)
+				Expect(err).Should(BeNil())
+				Expect(len(response)).To(Equal(1))
+				Expect(statuses).To(BeZero())
+			})
+
+			It("Should reject an invalid status project", func() {
+
+				project, _ := url.Parse("namespace/project.git")
+				uri := root.ResolveReference(project)
+
+				request := check.Request{
+					Source: pkg.Source{
+						URI:           uri.String(),
+						PrivateToken:  "$",
+						StatusProject: "fork",
+					},
+				}
+
+				_, err := command.Run(request)
+				Expect(err).To(MatchError("invalid value for status_project: fork"))
+				Expect(statuses).To(BeZero())
 			})
 
 		})
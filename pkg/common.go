@@ -2,17 +2,62 @@ package pkg
 
 import (
 	"crypto/tls"
+	"errors"
 	"fmt"
+	"github.com/samcontesse/gitlab-merge-request-resource/pkg/logging"
+	"github.com/xanzy/go-gitlab"
 	"net/http"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strings"
 )
 
 func Fatal(doing string, err error) {
-	fmt.Fprintf(os.Stderr, "error %s: %s\n", doing, err)
+	logging.Default().Error("error "+doing, "error", err)
 	os.Exit(1)
 }
 
+// GetLogger returns the logger configured by the source, redacting its private token and ssh keys.
+func GetLogger(source Source) (*logging.Logger, error) {
+	logger, err := logging.New(os.Stderr, source.LogLevel, source.LogFormat)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Redact(source.PrivateToken)
+	for _, key := range source.SshKeys {
+		logger.Redact(key.Key, key.Passphrase)
+	}
+
+	return logger, nil
+}
+
+// buildVariables are the Concourse build metadata variables available to templated params.
+var buildVariables = []string{
+	"BUILD_ID",
+	"BUILD_NAME",
+	"BUILD_JOB_NAME",
+	"BUILD_PIPELINE_NAME",
+	"BUILD_PIPELINE_INSTANCE_VARS",
+	"BUILD_TEAM_NAME",
+	"BUILD_CREATED_BY",
+	"ATC_EXTERNAL_URL",
+}
+
+// buildVariableReference matches the $VAR and ${VAR} references to the build variables.
+var buildVariableReference = regexp.MustCompile(`\$(?:\{(` + strings.Join(buildVariables, "|") + `)\}|(` +
+	strings.Join(buildVariables, "|") + `)\b)`)
+
+// ExpandBuildVariables replaces $VAR and ${VAR} occurrences of the Concourse build metadata variables in value,
+// any other text is left untouched.
+func ExpandBuildVariables(value string) string {
+	return buildVariableReference.ReplaceAllStringFunc(value, func(reference string) string {
+		match := buildVariableReference.FindStringSubmatch(reference)
+		return os.Getenv(match[1] + match[2])
+	})
+}
+
 func GetDefaultClient(insecure bool) *http.Client {
 	http.DefaultTransport.(*http.Transport).TLSClientConfig = &tls.Config{InsecureSkipVerify: insecure}
 	return http.DefaultClient
@@ -27,3 +72,58 @@ func matchPath(patterns []string, path string) bool {
 	}
 	return false
 }
+
+// GetLatestDiffVersion fetches the most recent diff version of a merge request, including its diffs.
+// It returns nil when the merge request has no diff version yet.
+func GetLatestDiffVersion(api *gitlab.Client, mr *gitlab.MergeRequest) (*gitlab.MergeRequestDiffVersion, error) {
+	versions, _, err := api.MergeRequests.GetMergeRequestDiffVersions(mr.ProjectID, mr.IID, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(versions) == 0 {
+		return nil, nil
+	}
+
+	version, _, err := api.MergeRequests.GetSingleMergeRequestDiffVersion(mr.ProjectID, mr.IID, versions[0].ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return version, nil
+}
+
+// SetCommitStatus sets the status of the merge request head on the project given by the status_project
+// source option. In auto mode, the status of a fork merge request is set on the source project when the
+// target project refuses it with a 403 or hides it with a 404, any other error failing right away.
+func SetCommitStatus(api *gitlab.Client, source Source, mr *gitlab.MergeRequest, options *gitlab.SetCommitStatusOptions) error {
+	project, err := source.GetStatusProject()
+	if err != nil {
+		return err
+	}
+
+	pid := mr.ProjectID
+	if project == StatusProjectSource {
+		pid = mr.SourceProjectID
+	}
+
+	_, _, err = api.Commits.SetCommitStatus(pid, mr.SHA, options)
+	if err == nil || project != StatusProjectAuto || mr.SourceProjectID == mr.ProjectID || !isRefused(err) {
+		return err
+	}
+
+	_, _, fallback := api.Commits.SetCommitStatus(mr.SourceProjectID, mr.SHA, options)
+	if fallback != nil {
+		return fmt.Errorf("setting status on source project %d: %v, after target project %d: %w", mr.SourceProjectID, fallback, mr.ProjectID, err)
+	}
+	return nil
+}
+
+// isRefused tells whether the api answered the request with a 403 or a 404.
+func isRefused(err error) bool {
+	var response *gitlab.ErrorResponse
+	if !errors.As(err, &response) || response.Response == nil {
+		return false
+	}
+	return response.Response.StatusCode == http.StatusForbidden || response.Response.StatusCode == http.StatusNotFound
+}
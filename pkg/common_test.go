@@ -0,0 +1,56 @@
+package pkg
+
+import (
+	"os"
+	"testing"
+)
+
+func TestExpandBuildVariables(t *testing.T) {
+	os.Setenv("BUILD_JOB_NAME", "unit")
+	os.Setenv("BUILD_PIPELINE_NAME", "baltic")
+	os.Setenv("HOME", "/root")
+
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"lint", "lint"},
+		{"$BUILD_PIPELINE_NAME/$BUILD_JOB_NAME", "baltic/unit"},
+		{"ci-${BUILD_JOB_NAME}", "ci-unit"},
+		{"$HOME", "$HOME"},
+		{"costs $5", "costs $5"},
+		{"${", "${"},
+		{"${BUILD_JOB_NAME", "${BUILD_JOB_NAME"},
+		{"$BUILD_JOB_NAMES", "$BUILD_JOB_NAMES"},
+		{"$BUILD_JOB_NAME-1", "unit-1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			if got := ExpandBuildVariables(tt.value); got != tt.want {
+				t.Errorf("ExpandBuildVariables() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetLogger(t *testing.T) {
+	tests := []struct {
+		level   string
+		format  string
+		wantErr bool
+	}{
+		{"", "", false},
+		{"debug", "json", false},
+		{"WARN", "text", false},
+		{"verbose", "", true},
+		{"", "xml", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.level+"/"+tt.format, func(t *testing.T) {
+			_, err := GetLogger(Source{LogLevel: tt.level, LogFormat: tt.format, PrivateToken: "secret"})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("GetLogger() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
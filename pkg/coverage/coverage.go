@@ -0,0 +1,172 @@
+// Package coverage computes the total coverage percentage of Cobertura XML, Go coverprofile, LCOV and plain
+// percentage reports.
+package coverage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	FormatCobertura = "cobertura"
+	FormatGo        = "go"
+	FormatLcov      = "lcov"
+	FormatPlain     = "plain"
+)
+
+// Detect guesses the format of a coverage report from its content.
+func Detect(content []byte) string {
+	trimmed := bytes.TrimSpace(content)
+	switch {
+	case bytes.HasPrefix(trimmed, []byte("<")):
+		return FormatCobertura
+	case bytes.HasPrefix(trimmed, []byte("mode:")):
+		return FormatGo
+	case bytes.Contains(trimmed, []byte("end_of_record")), bytes.HasPrefix(trimmed, []byte("TN:")), bytes.HasPrefix(trimmed, []byte("SF:")):
+		return FormatLcov
+	}
+	return FormatPlain
+}
+
+// Parse returns the total coverage percentage of a report, the format being detected when empty.
+func Parse(content []byte, format string) (float64, error) {
+	if format == "" {
+		format = Detect(content)
+	}
+
+	switch strings.ToLower(format) {
+	case FormatCobertura:
+		return parseCobertura(content)
+	case FormatGo:
+		return parseGo(content)
+	case FormatLcov:
+		return parseLcov(content)
+	case FormatPlain:
+		return parsePlain(content)
+	}
+
+	return 0, fmt.Errorf("invalid coverage format: %v", format)
+}
+
+func parseCobertura(content []byte) (float64, error) {
+	var report struct {
+		XMLName  xml.Name `xml:"coverage"`
+		LineRate *float64 `xml:"line-rate,attr"`
+	}
+
+	err := xml.Unmarshal(content, &report)
+	if err != nil {
+		return 0, err
+	}
+
+	if report.LineRate == nil {
+		return 0, errors.New("cobertura report has no line-rate")
+	}
+
+	return *report.LineRate * 100, nil
+}
+
+// parseGo computes the statement coverage of a Go coverprofile, blocks reported several times
+// (e.g. merged profiles) are counted once.
+func parseGo(content []byte) (float64, error) {
+	type block struct {
+		statements int
+		covered    bool
+	}
+
+	blocks := make(map[string]*block)
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "mode:") {
+			continue
+		}
+
+		// name.go:line.column,line.column numberOfStatements count
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			return 0, fmt.Errorf("invalid coverprofile line: %s", line)
+		}
+
+		statements, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return 0, fmt.Errorf("invalid coverprofile line: %s", line)
+		}
+
+		count, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return 0, fmt.Errorf("invalid coverprofile line: %s", line)
+		}
+
+		b, ok := blocks[fields[0]]
+		if !ok {
+			b = &block{statements: statements}
+			blocks[fields[0]] = b
+		}
+		b.covered = b.covered || count > 0
+	}
+
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+
+	total, covered := 0, 0
+	for _, b := range blocks {
+		total += b.statements
+		if b.covered {
+			covered += b.statements
+		}
+	}
+
+	return percentage(covered, total), nil
+}
+
+func parseLcov(content []byte) (float64, error) {
+	found, hit := 0, 0
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		var counter *int
+		switch {
+		case strings.HasPrefix(line, "LF:"):
+			counter = &found
+		case strings.HasPrefix(line, "LH:"):
+			counter = &hit
+		default:
+			continue
+		}
+
+		value, err := strconv.Atoi(line[3:])
+		if err != nil {
+			return 0, fmt.Errorf("invalid lcov line: %s", line)
+		}
+		*counter += value
+	}
+
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+
+	return percentage(hit, found), nil
+}
+
+func parsePlain(content []byte) (float64, error) {
+	value := strings.TrimSuffix(strings.TrimSpace(string(content)), "%")
+	coverage, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid coverage percentage: %q", value)
+	}
+	return coverage, nil
+}
+
+func percentage(covered, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(covered) * 100 / float64(total)
+}
@@ -0,0 +1,87 @@
+package coverage
+
+import (
+	"math"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		format  string
+		want    float64
+		wantErr bool
+	}{
+		{
+			name:    "cobertura",
+			content: `<?xml version="1.0" ?><coverage line-rate="0.8125" branch-rate="0.5" version="1"><packages/></coverage>`,
+			want:    81.25,
+		},
+		{
+			name:    "cobertura without line rate",
+			content: `<coverage></coverage>`,
+			wantErr: true,
+		},
+		{
+			name: "go coverprofile",
+			content: "mode: set\n" +
+				"example.com/pkg/a.go:3.10,5.2 3 1\n" +
+				"example.com/pkg/a.go:7.10,9.2 1 0\n" +
+				"example.com/pkg/a.go:7.10,9.2 1 0\n",
+			want: 75,
+		},
+		{
+			name: "go coverprofile merged",
+			content: "mode: count\n" +
+				"example.com/pkg/a.go:3.10,5.2 2 0\n" +
+				"example.com/pkg/a.go:3.10,5.2 2 4\n" +
+				"example.com/pkg/a.go:7.10,9.2 2 0\n",
+			want: 50,
+		},
+		{
+			name: "lcov",
+			content: "TN:\nSF:src/a.js\nDA:1,1\nLF:10\nLH:9\nend_of_record\n" +
+				"SF:src/b.js\nLF:10\nLH:1\nend_of_record\n",
+			want: 50,
+		},
+		{
+			name:    "plain percentage",
+			content: " 87.5%\n",
+			want:    87.5,
+		},
+		{
+			name:    "plain number",
+			content: "42",
+			want:    42,
+		},
+		{
+			name:    "plain garbage",
+			content: "n/a",
+			wantErr: true,
+		},
+		{
+			name:    "forced format",
+			content: "12",
+			format:  "lcov",
+			want:    0,
+		},
+		{
+			name:    "unknown format",
+			content: "12",
+			format:  "jacoco",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse([]byte(tt.content), tt.format)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("Parse() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
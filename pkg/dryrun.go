@@ -0,0 +1,69 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/samcontesse/gitlab-merge-request-resource/pkg/logging"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// DryRunTransport sends the read requests to the GitLab API and logs the others, the mutations, as JSON lines
+// on Output instead of sending them. The mutations are answered with an empty object, marked as suppressed for the
+// api call logs.
+type DryRunTransport struct {
+	Transport http.RoundTripper
+	Output    io.Writer
+}
+
+// DryRunEntry is the logged mutation, the body being the JSON request body or, for any other content, its type.
+type DryRunEntry struct {
+	DryRun      bool            `json:"dry_run"`
+	Method      string          `json:"method"`
+	URL         string          `json:"url"`
+	Body        json.RawMessage `json:"body,omitempty"`
+	ContentType string          `json:"content_type,omitempty"`
+}
+
+func (transport *DryRunTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	if request.Method == http.MethodGet || request.Method == http.MethodHead {
+		return transport.Transport.RoundTrip(request)
+	}
+
+	entry := DryRunEntry{DryRun: true, Method: request.Method, URL: request.URL.String()}
+	if request.Body != nil {
+		body, err := io.ReadAll(request.Body)
+		request.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		contentType := request.Header.Get("Content-Type")
+		if strings.HasPrefix(contentType, "application/json") && json.Valid(body) {
+			entry.Body = body
+		} else if len(body) > 0 {
+			entry.ContentType = contentType
+		}
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return nil, err
+	}
+	_, err = transport.Output.Write(append(line, '\n'))
+	if err != nil {
+		return nil, err
+	}
+
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": []string{"application/json"}, logging.SuppressedHeader: []string{"dry run"}},
+		Body:          io.NopCloser(bytes.NewReader([]byte("{}"))),
+		ContentLength: 2,
+		Request:       request,
+	}, nil
+}
@@ -0,0 +1,64 @@
+package pkg
+
+import (
+	"bytes"
+	"github.com/samcontesse/gitlab-merge-request-resource/pkg/logging"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDryRunTransport(t *testing.T) {
+	var sent []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sent = append(sent, r.Method)
+		w.Write([]byte(`[]`))
+	}))
+	defer server.Close()
+
+	var output bytes.Buffer
+	client := &http.Client{Transport: &DryRunTransport{Transport: http.DefaultTransport, Output: &output}}
+
+	tests := []struct {
+		method      string
+		contentType string
+		body        string
+		want        string
+		wantBody    string
+	}{
+		{http.MethodGet, "", "", "", `[]`},
+		{http.MethodPut, "application/json", `{"labels":"a,b"}`, `{"dry_run":true,"method":"PUT","url":"` + server.URL + `/x","body":{"labels":"a,b"}}`, `{}`},
+		{http.MethodPost, "multipart/form-data; boundary=b", "--b--", `{"dry_run":true,"method":"POST","url":"` + server.URL + `/x","content_type":"multipart/form-data; boundary=b"}`, `{}`},
+		{http.MethodDelete, "", "", `{"dry_run":true,"method":"DELETE","url":"` + server.URL + `/x"}`, `{}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method, func(t *testing.T) {
+			output.Reset()
+			request, _ := http.NewRequest(tt.method, server.URL+"/x", strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				request.Header.Set("Content-Type", tt.contentType)
+			}
+
+			response, err := client.Do(request)
+			if err != nil {
+				t.Fatalf("Do() error = %v", err)
+			}
+			body, _ := io.ReadAll(response.Body)
+			if string(body) != tt.wantBody {
+				t.Errorf("Do() body = %s, want %s", body, tt.wantBody)
+			}
+			if suppressed := response.Header.Get(logging.SuppressedHeader) != ""; suppressed != (tt.want != "") {
+				t.Errorf("Do() suppressed = %v, want %v", suppressed, tt.want != "")
+			}
+			if got := strings.TrimSpace(output.String()); got != tt.want {
+				t.Errorf("logged %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	if len(sent) != 1 || sent[0] != http.MethodGet {
+		t.Errorf("sent %v, want only the GET request", sent)
+	}
+}
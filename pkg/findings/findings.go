@@ -0,0 +1,188 @@
+// Package findings reads the results of linters and static analysers from SARIF and GitLab Code Quality reports.
+package findings
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+const (
+	FormatSarif       = "sarif"
+	FormatCodeQuality = "codequality"
+)
+
+// Finding is a single result of a report, located on a line of a file relative to the repository root.
+type Finding struct {
+	Path        string
+	Line        int
+	Severity    string
+	Rule        string
+	Message     string
+	Fingerprint string
+}
+
+// Detect guesses the format of a report from its content, SARIF reports being objects and Code Quality reports
+// arrays.
+func Detect(content []byte) string {
+	if bytes.HasPrefix(bytes.TrimSpace(content), []byte("[")) {
+		return FormatCodeQuality
+	}
+	return FormatSarif
+}
+
+// Parse returns the findings of a report, the format being detected when empty.
+func Parse(content []byte, format string) ([]Finding, error) {
+	if format == "" {
+		format = Detect(content)
+	}
+
+	switch strings.ToLower(format) {
+	case FormatSarif:
+		return parseSarif(content)
+	case FormatCodeQuality:
+		return parseCodeQuality(content)
+	}
+
+	return nil, fmt.Errorf("invalid findings format: %v", format)
+}
+
+func parseSarif(content []byte) ([]Finding, error) {
+	var report struct {
+		Runs []struct {
+			Results []struct {
+				RuleID  string `json:"ruleId"`
+				Level   string `json:"level"`
+				Message struct {
+					Text string `json:"text"`
+				} `json:"message"`
+				Locations []struct {
+					PhysicalLocation struct {
+						ArtifactLocation struct {
+							URI string `json:"uri"`
+						} `json:"artifactLocation"`
+						Region struct {
+							StartLine int `json:"startLine"`
+						} `json:"region"`
+					} `json:"physicalLocation"`
+				} `json:"locations"`
+				PartialFingerprints map[string]string `json:"partialFingerprints"`
+			} `json:"results"`
+		} `json:"runs"`
+	}
+
+	err := json.Unmarshal(content, &report)
+	if err != nil {
+		return nil, err
+	}
+
+	findings := make([]Finding, 0)
+	for _, run := range report.Runs {
+		for _, result := range run.Results {
+			finding := Finding{
+				Severity: result.Level,
+				Rule:     result.RuleID,
+				Message:  result.Message.Text,
+			}
+			if finding.Severity == "" {
+				finding.Severity = "warning"
+			}
+			if len(result.Locations) > 0 {
+				location := result.Locations[0].PhysicalLocation
+				finding.Path = cleanPath(location.ArtifactLocation.URI)
+				finding.Line = location.Region.StartLine
+			}
+			if len(result.PartialFingerprints) > 0 {
+				finding.Fingerprint = partialFingerprint(result.PartialFingerprints)
+			}
+			findings = append(findings, withFingerprint(finding))
+		}
+	}
+
+	return findings, nil
+}
+
+func parseCodeQuality(content []byte) ([]Finding, error) {
+	var report []struct {
+		Description string `json:"description"`
+		CheckName   string `json:"check_name"`
+		Fingerprint string `json:"fingerprint"`
+		Severity    string `json:"severity"`
+		Location    struct {
+			Path  string `json:"path"`
+			Lines struct {
+				Begin int `json:"begin"`
+			} `json:"lines"`
+			Positions struct {
+				Begin struct {
+					Line int `json:"line"`
+				} `json:"begin"`
+			} `json:"positions"`
+		} `json:"location"`
+	}
+
+	err := json.Unmarshal(content, &report)
+	if err != nil {
+		return nil, err
+	}
+
+	findings := make([]Finding, 0, len(report))
+	for _, issue := range report {
+		finding := Finding{
+			Path:        cleanPath(issue.Location.Path),
+			Line:        issue.Location.Lines.Begin,
+			Severity:    issue.Severity,
+			Rule:        issue.CheckName,
+			Message:     issue.Description,
+			Fingerprint: issue.Fingerprint,
+		}
+		if finding.Line == 0 {
+			finding.Line = issue.Location.Positions.Begin.Line
+		}
+		findings = append(findings, withFingerprint(finding))
+	}
+
+	return findings, nil
+}
+
+// cleanPath turns the uri of a file into a path relative to the repository root.
+func cleanPath(uri string) string {
+	uri = strings.TrimPrefix(uri, "file://")
+	return strings.TrimPrefix(uri, "./")
+}
+
+// partialFingerprint combines the partial fingerprints of a SARIF result, which survive the finding moving to
+// another line.
+func partialFingerprint(partials map[string]string) string {
+	keys := make([]string, 0, len(partials))
+	for key := range partials {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var content strings.Builder
+	for _, key := range keys {
+		fmt.Fprintf(&content, "%s=%s\n", key, partials[key])
+	}
+	hash := sha1.Sum([]byte(content.String()))
+	return hex.EncodeToString(hash[:])
+}
+
+// withFingerprint identifies a finding by its location, rule and message when the report does not. A reported
+// fingerprint with spaces is hashed, the fingerprint being embedded in a single word of the posted notes.
+func withFingerprint(finding Finding) Finding {
+	switch {
+	case finding.Fingerprint == "":
+		hash := sha1.Sum([]byte(fmt.Sprintf("%s:%d:%s:%s", finding.Path, finding.Line, finding.Rule, finding.Message)))
+		finding.Fingerprint = hex.EncodeToString(hash[:])
+	case strings.IndexFunc(finding.Fingerprint, unicode.IsSpace) >= 0:
+		hash := sha1.Sum([]byte(finding.Fingerprint))
+		finding.Fingerprint = hex.EncodeToString(hash[:])
+	}
+	return finding
+}
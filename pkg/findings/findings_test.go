@@ -0,0 +1,137 @@
+package findings
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		format  string
+		want    []Finding
+		wantErr bool
+	}{
+		{
+			name: "sarif",
+			content: `{"version": "2.1.0", "runs": [{"results": [
+				{"ruleId": "G104", "level": "error", "message": {"text": "Errors unhandled."},
+				 "locations": [{"physicalLocation": {"artifactLocation": {"uri": "file://pkg/main.go"}, "region": {"startLine": 12}}}]},
+				{"ruleId": "S1000", "message": {"text": "Use plain channel send."},
+				 "locations": [{"physicalLocation": {"artifactLocation": {"uri": "./pkg/util.go"}, "region": {"startLine": 3}}}]}
+			]}]}`,
+			want: []Finding{
+				{Path: "pkg/main.go", Line: 12, Severity: "error", Rule: "G104", Message: "Errors unhandled."},
+				{Path: "pkg/util.go", Line: 3, Severity: "warning", Rule: "S1000", Message: "Use plain channel send."},
+			},
+		},
+		{
+			name: "code quality",
+			content: `[
+				{"description": "Line too long", "check_name": "lll", "fingerprint": "abc", "severity": "minor",
+				 "location": {"path": "main.go", "lines": {"begin": 7}}},
+				{"description": "Complex function", "check_name": "gocyclo", "fingerprint": "def", "severity": "major",
+				 "location": {"path": "util.go", "positions": {"begin": {"line": 21, "column": 1}}}}
+			]`,
+			want: []Finding{
+				{Path: "main.go", Line: 7, Severity: "minor", Rule: "lll", Message: "Line too long", Fingerprint: "abc"},
+				{Path: "util.go", Line: 21, Severity: "major", Rule: "gocyclo", Message: "Complex function", Fingerprint: "def"},
+			},
+		},
+		{
+			name:    "explicit format",
+			content: `[]`,
+			format:  "codequality",
+			want:    []Finding{},
+		},
+		{
+			name:    "invalid json",
+			content: `{"runs": `,
+			wantErr: true,
+		},
+		{
+			name:    "invalid format",
+			content: `[]`,
+			format:  "checkstyle",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse([]byte(tt.content), tt.format)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			for i := range got {
+				if got[i].Fingerprint == "" {
+					t.Errorf("Parse() finding %d has no fingerprint", i)
+				}
+				if i < len(tt.want) && tt.want[i].Fingerprint == "" {
+					got[i].Fingerprint = ""
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFingerprintIsStable(t *testing.T) {
+	content := []byte(`{"runs": [{"results": [{"ruleId": "G104", "message": {"text": "Errors unhandled."},
+		"locations": [{"physicalLocation": {"artifactLocation": {"uri": "main.go"}, "region": {"startLine": 1}}}]}]}]}`)
+
+	first, _ := Parse(content, "")
+	second, _ := Parse(content, FormatSarif)
+	if first[0].Fingerprint != second[0].Fingerprint {
+		t.Errorf("fingerprints differ: %s and %s", first[0].Fingerprint, second[0].Fingerprint)
+	}
+}
+
+func TestReportedFingerprints(t *testing.T) {
+	sarif := func(line int, partials string) string {
+		return fmt.Sprintf(`{"runs": [{"results": [{"ruleId": "G104", "message": {"text": "Errors unhandled."},
+			"locations": [{"physicalLocation": {"artifactLocation": {"uri": "main.go"}, "region": {"startLine": %d}}}]%s}]}]}`, line, partials)
+	}
+	partials := `, "partialFingerprints": {"primaryLocationLineHash": "39fa2ee980eb94b0:1", "secondary": "a"}`
+	reordered := `, "partialFingerprints": {"secondary": "a", "primaryLocationLineHash": "39fa2ee980eb94b0:1"}`
+
+	tests := []struct {
+		name  string
+		first string
+		other string
+		same  bool
+	}{
+		{name: "partial fingerprints survive a move", first: sarif(1, partials), other: sarif(8, partials), same: true},
+		{name: "partial fingerprints are ordered", first: sarif(1, partials), other: sarif(1, reordered), same: true},
+		{name: "location without partial fingerprints", first: sarif(1, ""), other: sarif(8, ""), same: false},
+		{name: "partial fingerprints differ from location", first: sarif(1, partials), other: sarif(1, ""), same: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			first, _ := Parse([]byte(tt.first), "")
+			other, _ := Parse([]byte(tt.other), "")
+			if (first[0].Fingerprint == other[0].Fingerprint) != tt.same {
+				t.Errorf("fingerprints %s and %s, want same %v", first[0].Fingerprint, other[0].Fingerprint, tt.same)
+			}
+		})
+	}
+}
+
+func TestFingerprintWithSpaces(t *testing.T) {
+	content := []byte(`[{"description": "Line too long", "check_name": "lll", "fingerprint": "lll main.go 7",
+		"location": {"path": "main.go", "lines": {"begin": 7}}}]`)
+
+	got, _ := Parse(content, FormatCodeQuality)
+	if strings.ContainsAny(got[0].Fingerprint, " \t\n") {
+		t.Errorf("Parse() fingerprint %q has spaces", got[0].Fingerprint)
+	}
+}
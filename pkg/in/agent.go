@@ -1,50 +1,151 @@
 package in
 
 import (
+	"errors"
+	"github.com/samcontesse/gitlab-merge-request-resource/pkg"
+	gossh "golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"net"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
+	"time"
 )
 
 type AgentRunner interface {
 	Start() error
-	AddKey(key string) error
+	Stop() error
+	AddKey(key pkg.SshKey) error
+	SshCommand(knownHosts []string, check string) (string, error)
 }
 
 func NewAgentRunner() AgentRunner {
-	return &AgentRunnerImpl{
-		sockPath: "/tmp/ssh-agent.sock",
-	}
+	return &AgentRunnerImpl{}
 }
 
+// AgentRunnerImpl runs an ssh-agent listening on a socket unique to the invocation, the socket and the known
+// hosts live in a temporary directory removed when the agent stops.
 type AgentRunnerImpl struct {
-	sockPath string
-	agent    *exec.Cmd
+	dir   string
+	agent *exec.Cmd
 }
 
 func (r *AgentRunnerImpl) Start() error {
 	if r.agent != nil {
 		return nil // already running
 	}
-	agent := exec.Command("ssh-agent", "-a", r.sockPath)
-	agent.Stdin = os.Stdin
+
+	dir, err := r.workdir()
+	if err != nil {
+		return err
+	}
+
+	sock := filepath.Join(dir, "agent.sock")
+	agent := exec.Command("ssh-agent", "-D", "-a", sock)
 	agent.Stderr = os.Stderr
-	err := agent.Run()
+	err = agent.Start()
 	if err != nil {
 		return err
 	}
 	r.agent = agent
-	os.Setenv("SSH_AUTH_SOCK", r.sockPath)
+
+	// the agent runs in the foreground, wait for its socket
+	for i := 0; i < 50; i++ {
+		if _, err = os.Stat(sock); err == nil {
+			return os.Setenv("SSH_AUTH_SOCK", sock)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	_ = r.Stop()
+	return errors.New("ssh-agent did not create its socket")
+}
+
+func (r *AgentRunnerImpl) Stop() error {
+	if r.agent != nil {
+		_ = r.agent.Process.Kill()
+		_ = r.agent.Wait()
+		r.agent = nil
+		os.Unsetenv("SSH_AUTH_SOCK")
+	}
+
+	if r.dir != "" {
+		err := os.RemoveAll(r.dir)
+		if err != nil {
+			return err
+		}
+		r.dir = ""
+	}
+
 	return nil
 }
 
-func (r AgentRunnerImpl) AddKey(key string) error {
-	command := exec.Command("ssh-add", "-")
-	command.Stderr = os.Stderr
-	command.Stdin = strings.NewReader(key + "\n") // this trailing newline is mandatory or ssh-add will fail
-	err := command.Run()
+// AddKey decrypts the key with its passphrase, if any, and adds it to the running agent.
+// Errors never contain the key material.
+func (r *AgentRunnerImpl) AddKey(key pkg.SshKey) error {
+	var (
+		raw interface{}
+		err error
+	)
+
+	if key.Passphrase != "" {
+		raw, err = gossh.ParseRawPrivateKeyWithPassphrase([]byte(key.Key), []byte(key.Passphrase))
+	} else {
+		raw, err = gossh.ParseRawPrivateKey([]byte(key.Key))
+	}
 	if err != nil {
 		return err
 	}
-	return nil
+
+	if r.agent == nil {
+		return errors.New("ssh-agent is not running")
+	}
+
+	conn, err := net.Dial("unix", filepath.Join(r.dir, "agent.sock"))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return agent.NewClient(conn).Add(agent.AddedKey{PrivateKey: raw})
+}
+
+// SshCommand returns the ssh command verifying host keys according to check. The known hosts, when provided,
+// are written to a dedicated file which replaces the user known hosts.
+func (r *AgentRunnerImpl) SshCommand(knownHosts []string, check string) (string, error) {
+	if check == pkg.HostKeyCheckOff {
+		return "ssh -o UserKnownHostsFile=/dev/null -o StrictHostKeyChecking=no", nil
+	}
+
+	command := "ssh -o StrictHostKeyChecking=yes"
+	if check == pkg.HostKeyCheckAcceptNew {
+		command = "ssh -o StrictHostKeyChecking=accept-new"
+	}
+
+	if len(knownHosts) > 0 {
+		dir, err := r.workdir()
+		if err != nil {
+			return "", err
+		}
+		path := filepath.Join(dir, "known_hosts")
+		err = os.WriteFile(path, []byte(strings.Join(knownHosts, "\n")+"\n"), 0600)
+		if err != nil {
+			return "", err
+		}
+		command += " -o UserKnownHostsFile=" + path
+	}
+
+	return command, nil
+}
+
+func (r *AgentRunnerImpl) workdir() (string, error) {
+	if r.dir == "" {
+		dir, err := os.MkdirTemp("", "gitlab-merge-request-resource-ssh-")
+		if err != nil {
+			return "", err
+		}
+		r.dir = dir
+	}
+	return r.dir, nil
 }
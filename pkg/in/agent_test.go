@@ -0,0 +1,63 @@
+package in_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"os"
+	"os/exec"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/samcontesse/gitlab-merge-request-resource/pkg"
+	"github.com/samcontesse/gitlab-merge-request-resource/pkg/in"
+	"golang.org/x/crypto/ssh"
+)
+
+var _ = Describe("AgentRunner", func() {
+
+	var (
+		agent in.AgentRunner
+		key   pkg.SshKey
+	)
+
+	BeforeEach(func() {
+		if _, err := exec.LookPath("ssh-agent"); err != nil {
+			Skip("ssh-agent is not available")
+		}
+
+		_, private, _ := ed25519.GenerateKey(rand.Reader)
+		block, err := ssh.MarshalPrivateKeyWithPassphrase(private, "test", []byte("secret"))
+		Expect(err).Should(BeNil())
+		key = pkg.SshKey{Key: string(pem.EncodeToMemory(block)), Passphrase: "secret"}
+
+		agent = in.NewAgentRunner()
+		Expect(agent.Start()).To(Succeed())
+		DeferCleanup(agent.Stop)
+	})
+
+	It("Should add passphrase protected keys", func() {
+		Expect(agent.AddKey(key)).To(Succeed())
+
+		output, err := exec.Command("ssh-add", "-l").Output()
+		Expect(err).Should(BeNil())
+		Expect(string(output)).To(ContainSubstring("(ED25519)"))
+	})
+
+	It("Should not leak the key when the passphrase is wrong", func() {
+		key.Passphrase = "wrong"
+		err := agent.AddKey(key)
+		Expect(err).ShouldNot(BeNil())
+		Expect(err.Error()).NotTo(ContainSubstring("PRIVATE KEY"))
+	})
+
+	It("Should remove the agent socket when stopped", func() {
+		sock := os.Getenv("SSH_AUTH_SOCK")
+		Expect(sock).NotTo(BeEmpty())
+		Expect(agent.Stop()).To(Succeed())
+		_, err := os.Stat(sock)
+		Expect(os.IsNotExist(err)).To(BeTrue())
+		Expect(os.Getenv("SSH_AUTH_SOCK")).To(BeEmpty())
+	})
+
+})
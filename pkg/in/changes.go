@@ -0,0 +1,138 @@
+package in
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/xanzy/go-gitlab"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Changes is the manifest of the files changed by a merge request, as found in its latest diff version.
+type Changes struct {
+	Version        int          `json:"version"`
+	BaseCommitSHA  string       `json:"base_commit_sha"`
+	HeadCommitSHA  string       `json:"head_commit_sha"`
+	StartCommitSHA string       `json:"start_commit_sha"`
+	Files          []FileChange `json:"files"`
+}
+
+type FileChange struct {
+	Status      string `json:"status"`
+	OldPath     string `json:"old_path"`
+	NewPath     string `json:"new_path"`
+	AMode       string `json:"a_mode"`
+	BMode       string `json:"b_mode"`
+	NewFile     bool   `json:"new_file"`
+	RenamedFile bool   `json:"renamed_file"`
+	DeletedFile bool   `json:"deleted_file"`
+}
+
+func newChanges(version *gitlab.MergeRequestDiffVersion) Changes {
+	changes := Changes{Files: make([]FileChange, 0)}
+	if version == nil {
+		return changes
+	}
+
+	changes.Version = version.ID
+	changes.BaseCommitSHA = version.BaseCommitSHA
+	changes.HeadCommitSHA = version.HeadCommitSHA
+	changes.StartCommitSHA = version.StartCommitSHA
+
+	for _, d := range version.Diffs {
+		changes.Files = append(changes.Files, FileChange{
+			Status:      diffStatus(d),
+			OldPath:     d.OldPath,
+			NewPath:     d.NewPath,
+			AMode:       d.AMode,
+			BMode:       d.BMode,
+			NewFile:     d.NewFile,
+			RenamedFile: d.RenamedFile,
+			DeletedFile: d.DeletedFile,
+		})
+	}
+
+	return changes
+}
+
+// writeChanges writes the changed files list (in the format of git diff --name-status), the unified diff and
+// the JSON manifest of the given diff version into dir.
+func writeChanges(dir string, version *gitlab.MergeRequestDiffVersion) error {
+	var (
+		files strings.Builder
+		patch strings.Builder
+	)
+
+	if version != nil {
+		for _, d := range version.Diffs {
+			if d.RenamedFile {
+				fmt.Fprintf(&files, "R\t%s\t%s\n", d.OldPath, d.NewPath)
+			} else {
+				fmt.Fprintf(&files, "%s\t%s\n", diffStatus(d), d.NewPath)
+			}
+			writeUnifiedDiff(&patch, d)
+		}
+	}
+
+	err := os.WriteFile(filepath.Join(dir, "merge-request-changed-files"), []byte(files.String()), 0644)
+	if err != nil {
+		return err
+	}
+
+	err = os.WriteFile(filepath.Join(dir, "merge-request.diff"), []byte(patch.String()), 0644)
+	if err != nil {
+		return err
+	}
+
+	manifest, err := json.Marshal(newChanges(version))
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(dir, "merge-request-changes.json"), manifest, 0644)
+}
+
+func writeUnifiedDiff(patch *strings.Builder, d *gitlab.Diff) {
+	fmt.Fprintf(patch, "diff --git a/%s b/%s\n", d.OldPath, d.NewPath)
+
+	from, to := "a/"+d.OldPath, "b/"+d.NewPath
+	switch {
+	case d.NewFile:
+		fmt.Fprintf(patch, "new file mode %s\n", d.BMode)
+		from = "/dev/null"
+	case d.DeletedFile:
+		fmt.Fprintf(patch, "deleted file mode %s\n", d.AMode)
+		to = "/dev/null"
+	default:
+		if d.AMode != d.BMode {
+			fmt.Fprintf(patch, "old mode %s\nnew mode %s\n", d.AMode, d.BMode)
+		}
+		if d.RenamedFile {
+			fmt.Fprintf(patch, "rename from %s\nrename to %s\n", d.OldPath, d.NewPath)
+		}
+	}
+
+	if d.Diff == "" {
+		return
+	}
+
+	fmt.Fprintf(patch, "--- %s\n+++ %s\n", from, to)
+	patch.WriteString(d.Diff)
+	if !strings.HasSuffix(d.Diff, "\n") {
+		patch.WriteString("\n")
+	}
+}
+
+// diffStatus returns the git status letter of a file change.
+func diffStatus(d *gitlab.Diff) string {
+	switch {
+	case d.NewFile:
+		return "A"
+	case d.DeletedFile:
+		return "D"
+	case d.RenamedFile:
+		return "R"
+	}
+	return "M"
+}
@@ -2,17 +2,23 @@ package in
 
 import (
 	"encoding/json"
+	"fmt"
 	"github.com/samcontesse/gitlab-merge-request-resource/pkg"
+	"github.com/samcontesse/gitlab-merge-request-resource/pkg/logging"
 	"github.com/xanzy/go-gitlab"
 	"net/url"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
 )
 
 type Command struct {
 	client *gitlab.Client
 	runner GitRunner
 	agent  AgentRunner
+	logger *logging.Logger
 }
 
 func NewCommand(client *gitlab.Client) *Command {
@@ -20,6 +26,7 @@ func NewCommand(client *gitlab.Client) *Command {
 		client,
 		NewRunner(),
 		NewAgentRunner(),
+		logging.Default(),
 	}
 }
 
@@ -28,104 +35,232 @@ func (command *Command) WithRunner(runner GitRunner) *Command {
 	return command
 }
 
+func (command *Command) WithAgent(agent AgentRunner) *Command {
+	command.agent = agent
+	return command
+}
+
+func (command *Command) WithLogger(logger *logging.Logger) *Command {
+	command.logger = logger
+	return command
+}
+
 func (command *Command) Run(destination string, request Request) (Response, error) {
-	err := os.MkdirAll(destination, 0755)
+	err := validateFetch(request.Params.Fetch)
 	if err != nil {
 		return Response{}, err
 	}
 
-	user, _, err := command.client.Users.CurrentUser()
-	err = command.runner.Run("config", "--global", "user.email", user.Email)
+	err = os.MkdirAll(destination, 0755)
 	if err != nil {
 		return Response{}, err
 	}
 
-	err = command.runner.Run("config", "--global", "user.name", user.Name)
+	mr, _, err := command.client.MergeRequests.GetMergeRequest(request.Source.GetProjectPath(), request.Version.ID, &gitlab.GetMergeRequestsOptions{})
 	if err != nil {
 		return Response{}, err
 	}
 
-	mr, _, err := command.client.MergeRequests.GetMergeRequest(request.Source.GetProjectPath(), request.Version.ID, &gitlab.GetMergeRequestsOptions{})
+	mr.UpdatedAt = request.Version.UpdatedAt
+	command.logger.Info("fetched merge request", "iid", mr.IID, "sha", mr.SHA, "source", mr.SourceBranch, "target", mr.TargetBranch)
+
+	commit, _, err := command.client.Commits.GetCommit(mr.SourceProjectID, mr.SHA)
 	if err != nil {
 		return Response{}, err
 	}
 
-	mr.UpdatedAt = request.Version.UpdatedAt
+	if request.Params.SkipClone {
+		command.logger.Info("skipping clone")
+		err = os.MkdirAll(filepath.Join(destination, ".git"), 0755)
+	} else {
+		err = command.clone(destination, request, mr)
+	}
+	if err != nil {
+		return Response{}, err
+	}
 
-	target, err := command.createRepositoryUrl(mr.TargetProjectID, request.Source.PrivateToken)
+	notes, _ := json.Marshal(mr)
+	err = os.WriteFile(filepath.Join(destination, ".git", "merge-request.json"), notes, 0644)
 	if err != nil {
 		return Response{}, err
 	}
-	source, err := command.createRepositoryUrl(mr.SourceProjectID, request.Source.PrivateToken)
+
+	err = os.WriteFile(filepath.Join(destination, ".git", "merge-request-source-branch"), []byte(mr.SourceBranch), 0644)
 	if err != nil {
 		return Response{}, err
 	}
 
-	commit, _, err := command.client.Commits.GetCommit(mr.SourceProjectID, mr.SHA)
+	version, err := pkg.GetLatestDiffVersion(command.client, mr)
+	if err != nil {
+		return Response{}, err
+	}
+
+	err = writeChanges(filepath.Join(destination, ".git"), version)
+	if err != nil {
+		return Response{}, err
+	}
+
+	err = command.fetch(filepath.Join(destination, ".git"), request.Params.Fetch, mr)
 	if err != nil {
 		return Response{}, err
 	}
 
-	err = command.runner.Run("clone", "-c", "http.sslVerify="+strconv.FormatBool(!request.Source.Insecure), "-o", "target", "-b", mr.TargetBranch, target.String(), destination)
+	err = writeMetadataFiles(filepath.Join(destination, ".git", "merge-request"), mr)
 	if err != nil {
 		return Response{}, err
 	}
 
+	response := Response{Version: request.Version, Metadata: buildMetadata(mr, commit)}
+
+	return response, nil
+}
+
+// clone checks out the target branch into destination and merges the merge request head on top of it.
+func (command *Command) clone(destination string, request Request, mr *gitlab.MergeRequest) error {
+	name, email, err := command.getIdentity(request.Source)
+	if err != nil {
+		return err
+	}
+
+	target, err := command.createRepositoryUrl(mr.TargetProjectID, request.Source.PrivateToken)
+	if err != nil {
+		return err
+	}
+	source, err := command.createRepositoryUrl(mr.SourceProjectID, request.Source.PrivateToken)
+	if err != nil {
+		return err
+	}
+
+	err = command.git("", "clone", "-c", "http.sslVerify="+strconv.FormatBool(!request.Source.Insecure), "-o", "target", "-b", mr.TargetBranch, target.String(), destination)
+	if err != nil {
+		return err
+	}
+
+	err = command.git(destination, "config", "user.email", email)
+	if err != nil {
+		return err
+	}
+
+	err = command.git(destination, "config", "user.name", name)
+	if err != nil {
+		return err
+	}
+
+	// configuration passed with -c is inherited by the git processes spawned for submodules
+	var options []string
+
 	if (request.Source.SshKeys != nil) && (len(request.Source.SshKeys) != 0) {
-		err = command.runner.Run("config", "--global", "core.sshCommand", "ssh -o UserKnownHostsFile=/dev/null -o StrictHostKeyChecking=no")
+		check, err := request.Source.GetSshHostKeyCheck()
 		if err != nil {
-			return Response{}, err
+			return err
 		}
 		err = command.agent.Start()
 		if err != nil {
-			return Response{}, err
+			return err
+		}
+		defer command.agent.Stop()
+		ssh, err := command.agent.SshCommand(request.Source.SshKnownHosts, check)
+		if err != nil {
+			return err
 		}
-		for _, key := range request.Source.SshKeys {
+		options = append(options, "-c", "core.sshCommand="+ssh)
+		for i, key := range request.Source.SshKeys {
 			err = command.agent.AddKey(key)
 			if err != nil {
-				return Response{}, err
+				return fmt.Errorf("adding ssh key #%d: %w", i+1, err)
 			}
 		}
 	}
 
-	os.Chdir(destination)
-
-	err = command.runner.Run("remote", "add", "source", source.String())
+	err = command.git(destination, "remote", "add", "source", source.String())
 	if err != nil {
-		return Response{}, err
+		return err
 	}
 
-	err = command.runner.Run("remote", "update")
+	err = command.git(destination, "remote", "update")
 	if err != nil {
-		return Response{}, err
+		return err
 	}
 
-	err = command.runner.Run("merge", "--no-ff", "--no-commit", mr.SHA)
+	err = command.git(destination, "merge", "--no-ff", "--no-commit", mr.SHA)
 	if err != nil {
-		return Response{}, err
+		return err
 	}
 
 	if request.Source.Recursive {
-		err = command.runner.Run("submodule", "update", "--init", "--recursive")
+		args := append(options, insteadOf(request.Source)...)
+		args = append(args, "submodule", "update", "--init", "--recursive")
+		if request.Source.SubmoduleDepth > 0 {
+			args = append(args, "--depth", strconv.Itoa(request.Source.SubmoduleDepth))
+		}
+		if len(request.Source.SubmodulePaths) > 0 {
+			args = append(args, "--")
+			args = append(args, request.Source.SubmodulePaths...)
+		}
+		err = command.git(destination, args...)
 		if err != nil {
-			return Response{}, err
+			return err
 		}
 	}
 
-	notes, _ := json.Marshal(mr)
-	err = os.WriteFile(".git/merge-request.json", notes, 0644)
+	return nil
+}
+
+// git runs a git command in dir, logging it with its duration.
+func (command *Command) git(dir string, args ...string) error {
+	start := time.Now()
+	err := command.runner.Run(dir, args...)
+	duration := time.Since(start).Round(time.Millisecond)
 	if err != nil {
-		return Response{}, err
+		command.logger.Warn("git command failed", "dir", dir, "args", strings.Join(args, " "), "duration", duration, "error", err)
+		return err
+	}
+	command.logger.Info("git command", "dir", dir, "args", strings.Join(args, " "), "duration", duration)
+	return nil
+}
+
+// getIdentity returns the git user name and email from the source configuration, falling back to the
+// GitLab user owning the private token when they are not configured.
+func (command *Command) getIdentity(source pkg.Source) (string, string, error) {
+	if source.GitUserName != "" && source.GitUserEmail != "" {
+		return source.GitUserName, source.GitUserEmail, nil
 	}
 
-	err = os.WriteFile(".git/merge-request-source-branch", []byte(mr.SourceBranch), 0644)
+	user, _, err := command.client.Users.CurrentUser()
 	if err != nil {
-		return Response{}, err
+		return "", "", err
 	}
 
-	response := Response{Version: request.Version, Metadata: buildMetadata(mr, commit)}
+	name, email := source.GitUserName, source.GitUserEmail
+	if name == "" {
+		name = user.Name
+	}
+	if email == "" {
+		email = user.Email
+	}
 
-	return response, nil
+	return name, email, nil
+}
+
+// insteadOf returns the configuration rewriting the https and ssh urls of the submodules hosted on the GitLab
+// server of the resource to authenticated https urls.
+func insteadOf(source pkg.Source) []string {
+	u, err := url.Parse(source.URI)
+	if err != nil || u.Host == "" {
+		return nil
+	}
+
+	authenticated := *u
+	authenticated.User = url.UserPassword("gitlab-ci-token", source.PrivateToken)
+	authenticated.Path = "/"
+	authenticated.RawQuery = ""
+	key := "url." + authenticated.String() + ".insteadOf="
+
+	return []string{
+		"-c", key + u.Scheme + "://" + u.Host + "/",
+		"-c", key + "git@" + u.Hostname() + ":",
+		"-c", key + "ssh://git@" + u.Hostname() + "/",
+	}
 }
 
 func (command *Command) createRepositoryUrl(pid int, token string) (*url.URL, error) {
@@ -182,5 +317,32 @@ func buildMetadata(mr *gitlab.MergeRequest, commit *gitlab.Commit) pkg.Metadata
 			Name:  "url",
 			Value: mr.WebURL,
 		},
+		{
+			Name:  "author_username",
+			Value: mr.Author.Username,
+		},
+		{
+			Name:  "labels",
+			Value: strings.Join(mr.Labels, ","),
+		},
+		{
+			Name:  "draft",
+			Value: strconv.FormatBool(isDraft(mr)),
+		},
+		{
+			Name:  "created_at",
+			Value: formatTime(mr.CreatedAt),
+		},
+		{
+			Name:  "updated_at",
+			Value: formatTime(mr.UpdatedAt),
+		},
+	}
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return ""
 	}
+	return t.Format(time.RFC3339)
 }
@@ -25,6 +25,7 @@ var _ = Describe("In", func() {
 		mux         *http.ServeMux
 		server      *httptest.Server
 		command     *in.Command
+		runner      *mockRunner
 		root        *url.URL
 		destination string
 	)
@@ -38,7 +39,8 @@ var _ = Describe("In", func() {
 		context, _ := url.Parse("/api/v4")
 		base := root.ResolveReference(context)
 		client, _ := gitlab.NewClient("$", gitlab.WithBaseURL(base.String()))
-		command = in.NewCommand(client).WithRunner(newMockRunner(destination))
+		runner = newMockRunner(destination)
+		command = in.NewCommand(client).WithRunner(runner)
 
 	})
 
@@ -60,8 +62,12 @@ var _ = Describe("In", func() {
 					SourceProjectID: 42,
 					SourceBranch:    "source-branch",
 					TargetBranch:    "target-branch",
-					Author:          &gitlab.BasicUser{Name: "Tester"},
+					Title:           "Fix it's broken",
+					Labels:          gitlab.Labels{"bug", "ci::running"},
+					Author:          &gitlab.BasicUser{Name: "Tester", Username: "tester"},
 				}
+				mr.DiffRefs.BaseSha = "base"
+				mr.DiffRefs.HeadSha = "abc"
 				output, _ := json.Marshal(mr)
 				w.Header().Set("content-type", "application/json")
 				w.WriteHeader(http.StatusOK)
@@ -83,6 +89,29 @@ var _ = Describe("In", func() {
 				w.WriteHeader(http.StatusOK)
 				w.Write(output)
 			})
+			mux.HandleFunc("/api/v4/projects/42/merge_requests/88/versions", func(w http.ResponseWriter, r *http.Request) {
+				versions := []gitlab.MergeRequestDiffVersion{{ID: 7}}
+				output, _ := json.Marshal(versions)
+				w.Header().Set("content-type", "application/json")
+				w.WriteHeader(http.StatusOK)
+				w.Write(output)
+			})
+			mux.HandleFunc("/api/v4/projects/42/merge_requests/88/versions/7", func(w http.ResponseWriter, r *http.Request) {
+				version := gitlab.MergeRequestDiffVersion{
+					ID:            7,
+					BaseCommitSHA: "base",
+					HeadCommitSHA: "abc",
+					Diffs: []*gitlab.Diff{
+						{OldPath: "main.go", NewPath: "main.go", AMode: "100644", BMode: "100644", Diff: "@@ -1 +1 @@\n-a\n+b\n"},
+						{OldPath: "README", NewPath: "README.md", AMode: "100644", BMode: "100644", RenamedFile: true},
+						{OldPath: "new.go", NewPath: "new.go", BMode: "100644", NewFile: true, Diff: "@@ -0,0 +1 @@\n+c\n"},
+					},
+				}
+				output, _ := json.Marshal(version)
+				w.Header().Set("content-type", "application/json")
+				w.WriteHeader(http.StatusOK)
+				w.Write(output)
+			})
 			mux.HandleFunc("/api/v4/user", func(w http.ResponseWriter, r *http.Request) {
 				user := gitlab.User{
 					Username: "test",
@@ -118,22 +147,325 @@ var _ = Describe("In", func() {
 				sb, _ := os.ReadFile(filepath.Join(destination, ".git", "merge-request-source-branch"))
 				Expect(string(sb)).Should(Equal("source-branch"))
 			})
+
+			It("Should write merge request metadata files", func() {
+				project, _ := url.Parse("namespace/project.git")
+				uri := root.ResolveReference(project)
+
+				request := in.Request{
+					Source: pkg.Source{
+						URI:          uri.String(),
+						PrivateToken: "$",
+					},
+					Version: pkg.Version{ID: 1},
+				}
+
+				response, err := command.Run(destination, request)
+				Expect(err).Should(BeNil())
+				Expect(response.Metadata).To(ContainElement(pkg.MetadataField{Name: "author_username", Value: "tester"}))
+				Expect(response.Metadata).To(ContainElement(pkg.MetadataField{Name: "labels", Value: "bug,ci::running"}))
+				Expect(response.Metadata).To(ContainElement(pkg.MetadataField{Name: "draft", Value: "false"}))
+
+				dir := filepath.Join(destination, ".git", "merge-request")
+				labels, _ := os.ReadFile(filepath.Join(dir, "labels"))
+				Expect(string(labels)).To(Equal("bug\nci::running\n"))
+				base, _ := os.ReadFile(filepath.Join(dir, "base_sha"))
+				Expect(string(base)).To(Equal("base"))
+				username, _ := os.ReadFile(filepath.Join(dir, "author_username"))
+				Expect(string(username)).To(Equal("tester"))
+				env, _ := os.ReadFile(filepath.Join(dir, "merge-request.env"))
+				Expect(string(env)).To(ContainSubstring(`MR_TITLE='Fix it'\''s broken'`))
+				Expect(string(env)).To(ContainSubstring("MR_IID='88'"))
+			})
+		})
+
+		Context("When a git identity is configured", func() {
+
+			It("Should scope the git configuration to the repository", func() {
+				cwd, _ := os.Getwd()
+				project, _ := url.Parse("namespace/project.git")
+				uri := root.ResolveReference(project)
+
+				request := in.Request{
+					Source: pkg.Source{
+						URI:          uri.String(),
+						PrivateToken: "$",
+						GitUserName:  "Concourse",
+						GitUserEmail: "ci@example.com",
+					},
+					Version: pkg.Version{ID: 1},
+				}
+
+				_, err := command.Run(destination, request)
+				Expect(err).Should(BeNil())
+				Expect(runner.calls).To(ContainElement("-C " + destination + " config user.name Concourse"))
+				Expect(runner.calls).To(ContainElement("-C " + destination + " config user.email ci@example.com"))
+				Expect(runner.calls).To(ContainElement("-C " + destination + " merge --no-ff --no-commit abc"))
+				for _, call := range runner.calls {
+					Expect(call).NotTo(ContainSubstring("--global"))
+				}
+				Expect(os.Getwd()).To(Equal(cwd))
+			})
+		})
+
+		Context("When ssh keys are configured", func() {
+
+			It("Should verify host keys against the known hosts", func() {
+				project, _ := url.Parse("namespace/project.git")
+				uri := root.ResolveReference(project)
+
+				request := in.Request{
+					Source: pkg.Source{
+						URI:           uri.String(),
+						PrivateToken:  "$",
+						Recursive:     true,
+						SshKeys:       []pkg.SshKey{{Key: "key"}},
+						SshKnownHosts: []string{"gitlab.example.com ssh-ed25519 AAAA"},
+					},
+					Version: pkg.Version{ID: 1},
+				}
+
+				agent := &mockAgent{}
+				_, err := command.WithAgent(agent).Run(destination, request)
+				Expect(err).Should(BeNil())
+				Expect(agent.keys).To(Equal([]string{"key"}))
+				Expect(agent.stopped).To(BeTrue())
+				Expect(runner.calls).To(ContainElement(And(
+					HavePrefix("-C "+destination+" -c core.sshCommand=ssh strict 1 "),
+					HaveSuffix(" submodule update --init --recursive"),
+				)))
+			})
+
+			It("Should reject an invalid host key check", func() {
+				project, _ := url.Parse("namespace/project.git")
+				uri := root.ResolveReference(project)
+
+				request := in.Request{
+					Source: pkg.Source{
+						URI:             uri.String(),
+						PrivateToken:    "$",
+						SshKeys:         []pkg.SshKey{{Key: "key"}},
+						SshHostKeyCheck: "maybe",
+					},
+					Version: pkg.Version{ID: 1},
+				}
+
+				_, err := command.WithAgent(&mockAgent{}).Run(destination, request)
+				Expect(err).Should(MatchError("invalid value for ssh_host_key_check: maybe"))
+			})
+		})
+
+		Context("When submodules are hosted on the same server", func() {
+
+			It("Should rewrite their urls to authenticated https", func() {
+				project, _ := url.Parse("namespace/project.git")
+				uri := root.ResolveReference(project)
+
+				request := in.Request{
+					Source: pkg.Source{
+						URI:            uri.String(),
+						PrivateToken:   "$",
+						Recursive:      true,
+						SubmoduleDepth: 1,
+						SubmodulePaths: []string{"libs/common"},
+					},
+					Version: pkg.Version{ID: 1},
+				}
+
+				_, err := command.Run(destination, request)
+				Expect(err).Should(BeNil())
+
+				base := "url.http://gitlab-ci-token:$@" + root.Host + "/.insteadOf="
+				Expect(runner.calls).To(ContainElement("-C " + destination +
+					" -c " + base + "http://" + root.Host + "/" +
+					" -c " + base + "git@127.0.0.1:" +
+					" -c " + base + "ssh://git@127.0.0.1/" +
+					" submodule update --init --recursive --depth 1 -- libs/common"))
+			})
+		})
+
+		Context("When the agent builds the ssh command", func() {
+
+			It("Should disable host key verification only when asked to", func() {
+				agent := in.NewAgentRunner()
+				Expect(agent.SshCommand(nil, pkg.HostKeyCheckOff)).To(Equal("ssh -o UserKnownHostsFile=/dev/null -o StrictHostKeyChecking=no"))
+				Expect(agent.SshCommand(nil, pkg.HostKeyCheckAcceptNew)).To(Equal("ssh -o StrictHostKeyChecking=accept-new"))
+				Expect(agent.SshCommand(nil, pkg.HostKeyCheckStrict)).To(Equal("ssh -o StrictHostKeyChecking=yes"))
+			})
+		})
+
+		Context("When the merge request has changes", func() {
+
+			It("Should write changed files, diff and manifest", func() {
+				project, _ := url.Parse("namespace/project.git")
+				uri := root.ResolveReference(project)
+
+				request := in.Request{
+					Source: pkg.Source{
+						URI:          uri.String(),
+						PrivateToken: "$",
+					},
+					Version: pkg.Version{ID: 1},
+				}
+
+				_, err := command.Run(destination, request)
+				Expect(err).Should(BeNil())
+
+				files, _ := os.ReadFile(filepath.Join(destination, ".git", "merge-request-changed-files"))
+				Expect(string(files)).To(Equal("M\tmain.go\nR\tREADME\tREADME.md\nA\tnew.go\n"))
+
+				diff, _ := os.ReadFile(filepath.Join(destination, ".git", "merge-request.diff"))
+				Expect(string(diff)).To(ContainSubstring("--- a/main.go\n+++ b/main.go\n@@ -1 +1 @@\n-a\n+b\n"))
+				Expect(string(diff)).To(ContainSubstring("rename from README\nrename to README.md\n"))
+				Expect(string(diff)).To(ContainSubstring("new file mode 100644\n--- /dev/null\n+++ b/new.go\n"))
+
+				content, _ := os.ReadFile(filepath.Join(destination, ".git", "merge-request-changes.json"))
+				var changes in.Changes
+				Expect(json.Unmarshal(content, &changes)).To(Succeed())
+				Expect(changes.Version).To(Equal(7))
+				Expect(changes.BaseCommitSHA).To(Equal("base"))
+				Expect(changes.Files).To(HaveLen(3))
+				Expect(changes.Files[2].Status).To(Equal("A"))
+			})
+		})
+
+		Context("When extra resources are fetched", func() {
+
+			BeforeEach(func() {
+				mux.HandleFunc("/api/v4/projects/42/merge_requests/88/discussions", func(w http.ResponseWriter, r *http.Request) {
+					discussions := []gitlab.Discussion{{ID: "d1", Notes: []*gitlab.Note{{ID: 1, Resolvable: true, Resolved: true}}}}
+					output, _ := json.Marshal(discussions)
+					w.Header().Set("content-type", "application/json")
+					w.WriteHeader(http.StatusOK)
+					w.Write(output)
+				})
+				mux.HandleFunc("/api/v4/projects/42/merge_requests/88/approvals", func(w http.ResponseWriter, r *http.Request) {
+					approvals := gitlab.MergeRequestApprovals{IID: 88, Approved: true}
+					output, _ := json.Marshal(approvals)
+					w.Header().Set("content-type", "application/json")
+					w.WriteHeader(http.StatusOK)
+					w.Write(output)
+				})
+				mux.HandleFunc("/api/v4/projects/42/merge_requests/88/commits", func(w http.ResponseWriter, r *http.Request) {
+					commits := []gitlab.Commit{{ID: "abc"}}
+					output, _ := json.Marshal(commits)
+					w.Header().Set("content-type", "application/json")
+					w.WriteHeader(http.StatusOK)
+					w.Write(output)
+				})
+			})
+
+			It("Should write each resource to its own file", func() {
+				project, _ := url.Parse("namespace/project.git")
+				uri := root.ResolveReference(project)
+
+				request := in.Request{
+					Source: pkg.Source{
+						URI:          uri.String(),
+						PrivateToken: "$",
+					},
+					Version: pkg.Version{ID: 1},
+					Params:  in.Params{Fetch: []string{"discussions", "approvals", "pipelines", "commits"}},
+				}
+
+				_, err := command.Run(destination, request)
+				Expect(err).Should(BeNil())
+
+				discussions, _ := os.ReadFile(filepath.Join(destination, ".git", "merge-request-discussions.json"))
+				Expect(string(discussions)).To(ContainSubstring(`"resolved":true`))
+				approvals, _ := os.ReadFile(filepath.Join(destination, ".git", "merge-request-approvals.json"))
+				Expect(string(approvals)).To(ContainSubstring(`"approved":true`))
+				pipeline, _ := os.ReadFile(filepath.Join(destination, ".git", "merge-request-pipeline.json"))
+				Expect(string(pipeline)).To(Equal(`{"pipeline":null,"jobs":[]}`))
+				commits, _ := os.ReadFile(filepath.Join(destination, ".git", "merge-request-commits.json"))
+				Expect(string(commits)).To(ContainSubstring(`"id":"abc"`))
+			})
+
+			It("Should reject unknown resources", func() {
+				request := in.Request{
+					Version: pkg.Version{ID: 1},
+					Params:  in.Params{Fetch: []string{"reactions"}},
+				}
+
+				_, err := command.Run(destination, request)
+				Expect(err).Should(MatchError("invalid value for fetch: reactions"))
+			})
+		})
+
+		Context("When clone is skipped", func() {
+
+			It("Should only write merge request files", func() {
+				project, _ := url.Parse("namespace/project.git")
+				uri := root.ResolveReference(project)
+
+				request := in.Request{
+					Source: pkg.Source{
+						URI:          uri.String(),
+						PrivateToken: "$",
+					},
+					Version: pkg.Version{ID: 1},
+					Params:  in.Params{SkipClone: true},
+				}
+
+				response, err := command.WithRunner(failingRunner{}).Run(destination, request)
+				Expect(err).Should(BeNil())
+				Expect(response.Metadata[0].Value).To(Equal("99"))
+				_, err = os.Stat(filepath.Join(destination, ".git", "merge-request.json"))
+				Expect(err).Should(BeNil())
+				sb, _ := os.ReadFile(filepath.Join(destination, ".git", "merge-request-source-branch"))
+				Expect(string(sb)).Should(Equal("source-branch"))
+			})
 		})
 
 	})
 
 })
 
-func newMockRunner(destination string) in.GitRunner {
+func newMockRunner(destination string) *mockRunner {
 	os.MkdirAll(filepath.Join(destination, ".git"), 0755)
-	return mockRunner{destination}
+	return &mockRunner{destination: destination}
 }
 
 type mockRunner struct {
 	destination string
+	calls       []string
 }
 
-func (mock mockRunner) Run(args ...string) error {
-	fmt.Printf("mock: git %s\n", strings.Join(args, " "))
+func (mock *mockRunner) Run(dir string, args ...string) error {
+	call := strings.Join(args, " ")
+	if dir != "" {
+		call = "-C " + dir + " " + call
+	}
+	fmt.Printf("mock: git %s\n", call)
+	mock.calls = append(mock.calls, call)
 	return nil
 }
+
+type failingRunner struct{}
+
+func (failingRunner) Run(dir string, args ...string) error {
+	return fmt.Errorf("unexpected git %s", strings.Join(args, " "))
+}
+
+type mockAgent struct {
+	keys    []string
+	stopped bool
+}
+
+func (mock *mockAgent) Start() error {
+	return nil
+}
+
+func (mock *mockAgent) Stop() error {
+	mock.stopped = true
+	return nil
+}
+
+func (mock *mockAgent) AddKey(key pkg.SshKey) error {
+	mock.keys = append(mock.keys, key.Key)
+	return nil
+}
+
+func (mock *mockAgent) SshCommand(knownHosts []string, check string) (string, error) {
+	return fmt.Sprintf("ssh %s %d", check, len(knownHosts)), nil
+}
@@ -0,0 +1,132 @@
+package in
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/xanzy/go-gitlab"
+	"os"
+	"path/filepath"
+)
+
+// Resources that can be requested with the fetch param, each one is written to its own file next to merge-request.json.
+const (
+	FetchDiscussions = "discussions"
+	FetchApprovals   = "approvals"
+	FetchPipelines   = "pipelines"
+	FetchCommits     = "commits"
+)
+
+var fetchFiles = map[string]string{
+	FetchDiscussions: "merge-request-discussions.json",
+	FetchApprovals:   "merge-request-approvals.json",
+	FetchPipelines:   "merge-request-pipeline.json",
+	FetchCommits:     "merge-request-commits.json",
+}
+
+// Pipeline is the head pipeline of a merge request along with its jobs.
+type Pipeline struct {
+	Pipeline *gitlab.Pipeline `json:"pipeline"`
+	Jobs     []*gitlab.Job    `json:"jobs"`
+}
+
+func validateFetch(resources []string) error {
+	for _, resource := range resources {
+		if _, ok := fetchFiles[resource]; !ok {
+			return fmt.Errorf("invalid value for fetch: %v", resource)
+		}
+	}
+	return nil
+}
+
+// fetch retrieves the requested resources of the merge request and writes them as JSON into dir.
+func (command *Command) fetch(dir string, resources []string, mr *gitlab.MergeRequest) error {
+	for _, resource := range resources {
+		var (
+			data interface{}
+			err  error
+		)
+
+		switch resource {
+		case FetchDiscussions:
+			data, err = command.listDiscussions(mr)
+		case FetchApprovals:
+			data, _, err = command.client.MergeRequestApprovals.GetConfiguration(mr.ProjectID, mr.IID)
+		case FetchPipelines:
+			data, err = command.getHeadPipeline(mr)
+		case FetchCommits:
+			data, err = command.listCommits(mr)
+		}
+		if err != nil {
+			return fmt.Errorf("fetching %s: %w", resource, err)
+		}
+
+		content, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+
+		err = os.WriteFile(filepath.Join(dir, fetchFiles[resource]), content, 0644)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (command *Command) listDiscussions(mr *gitlab.MergeRequest) ([]*gitlab.Discussion, error) {
+	discussions := make([]*gitlab.Discussion, 0)
+	options := &gitlab.ListMergeRequestDiscussionsOptions{PerPage: 100}
+	for {
+		page, response, err := command.client.Discussions.ListMergeRequestDiscussions(mr.ProjectID, mr.IID, options)
+		if err != nil {
+			return nil, err
+		}
+		discussions = append(discussions, page...)
+		if response.NextPage == 0 {
+			return discussions, nil
+		}
+		options.Page = response.NextPage
+	}
+}
+
+func (command *Command) listCommits(mr *gitlab.MergeRequest) ([]*gitlab.Commit, error) {
+	commits := make([]*gitlab.Commit, 0)
+	options := &gitlab.GetMergeRequestCommitsOptions{PerPage: 100}
+	for {
+		page, response, err := command.client.MergeRequests.GetMergeRequestCommits(mr.ProjectID, mr.IID, options)
+		if err != nil {
+			return nil, err
+		}
+		commits = append(commits, page...)
+		if response.NextPage == 0 {
+			return commits, nil
+		}
+		options.Page = response.NextPage
+	}
+}
+
+func (command *Command) getHeadPipeline(mr *gitlab.MergeRequest) (*Pipeline, error) {
+	result := &Pipeline{Jobs: make([]*gitlab.Job, 0)}
+	if mr.HeadPipeline == nil {
+		return result, nil
+	}
+
+	pipeline, _, err := command.client.Pipelines.GetPipeline(mr.HeadPipeline.ProjectID, mr.HeadPipeline.ID)
+	if err != nil {
+		return nil, err
+	}
+	result.Pipeline = pipeline
+
+	options := &gitlab.ListJobsOptions{ListOptions: gitlab.ListOptions{PerPage: 100}}
+	for {
+		page, response, err := command.client.Jobs.ListPipelineJobs(pipeline.ProjectID, pipeline.ID, options)
+		if err != nil {
+			return nil, err
+		}
+		result.Jobs = append(result.Jobs, page...)
+		if response.NextPage == 0 {
+			return result, nil
+		}
+		options.Page = response.NextPage
+	}
+}
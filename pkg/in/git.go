@@ -1,23 +1,42 @@
 package in
 
 import (
+	"github.com/samcontesse/gitlab-merge-request-resource/pkg"
 	"os"
 	"os/exec"
 )
 
+// GitRunner runs git commands in a repository directory, an empty dir runs them in the current directory.
 type GitRunner interface {
-	Run(args ...string) error
+	Run(dir string, args ...string) error
 }
 
 func NewRunner() GitRunner {
 	return DefaultRunner{}
 }
 
+// NewSourceRunner creates the GitRunner of the git backend selected in the source configuration.
+func NewSourceRunner(source pkg.Source) (GitRunner, error) {
+	backend, err := source.GetGitBackend()
+	if err != nil {
+		return nil, err
+	}
+
+	if backend == pkg.GitBackendGoGit {
+		return NewGoGitRunner(), nil
+	}
+
+	return NewRunner(), nil
+}
+
 type DefaultRunner struct {
 }
 
-func (r DefaultRunner) Run(args ...string) error {
+func (r DefaultRunner) Run(dir string, args ...string) error {
 	cmd := "git"
+	if dir != "" {
+		args = append([]string{"-C", dir}, args...)
+	}
 	command := exec.Command(cmd, args...)
 	command.Stdin = os.Stdin
 	command.Stderr = os.Stderr
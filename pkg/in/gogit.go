@@ -0,0 +1,850 @@
+package in
+
+import (
+	"errors"
+	"fmt"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"github.com/go-git/go-git/v5/utils/diff"
+	"github.com/sergi/go-diff/diffmatchpatch"
+	gossh "golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"io"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GoGitRunner is a GitRunner built on go-git, it does not need a git binary.
+// It understands the subset of git commands issued by the in command: config, clone, remote, merge and submodule.
+type GoGitRunner struct {
+	insecure   bool
+	insteadOf  map[string]string
+	sshCommand string
+}
+
+func NewGoGitRunner() GitRunner {
+	return &GoGitRunner{insteadOf: make(map[string]string)}
+}
+
+func (r *GoGitRunner) Run(dir string, args ...string) error {
+	// only the ssh command and the url rewrites are meaningful to go-git among the configuration overrides
+	for len(args) >= 2 && args[0] == "-c" {
+		key, value, _ := strings.Cut(args[1], "=")
+		switch {
+		case key == "core.sshCommand":
+			r.sshCommand = value
+		case strings.HasPrefix(key, "url.") && strings.HasSuffix(key, ".insteadOf"):
+			r.insteadOf[value] = strings.TrimSuffix(strings.TrimPrefix(key, "url."), ".insteadOf")
+		}
+		args = args[2:]
+	}
+
+	if len(args) == 0 {
+		return errors.New("missing git command")
+	}
+
+	if dir == "" {
+		dir = "."
+	}
+
+	switch args[0] {
+	case "config":
+		return r.config(dir, args[1:])
+	case "clone":
+		return r.clone(args[1:])
+	case "remote":
+		return r.remote(dir, args[1:])
+	case "merge":
+		return r.merge(dir, args[1:])
+	case "submodule":
+		return r.submodule(dir, args[1:])
+	}
+
+	return fmt.Errorf("unsupported git command for go-git backend: %s", args[0])
+}
+
+func (r *GoGitRunner) config(dir string, args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("unsupported config command for go-git backend: %s", strings.Join(args, " "))
+	}
+
+	repository, err := git.PlainOpen(dir)
+	if err != nil {
+		return err
+	}
+
+	cfg, err := repository.Config()
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "user.name":
+		cfg.User.Name = args[1]
+	case "user.email":
+		cfg.User.Email = args[1]
+	default:
+		return nil
+	}
+
+	return repository.SetConfig(cfg)
+}
+
+func (r *GoGitRunner) clone(args []string) error {
+	options := &git.CloneOptions{}
+	var positional []string
+
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "-c":
+			i++
+			if i < len(args) && strings.HasPrefix(args[i], "http.sslVerify=") {
+				verify, err := strconv.ParseBool(strings.TrimPrefix(args[i], "http.sslVerify="))
+				if err != nil {
+					return err
+				}
+				r.insecure = !verify
+			}
+		case "-o":
+			i++
+			if i < len(args) {
+				options.RemoteName = args[i]
+			}
+		case "-b":
+			i++
+			if i < len(args) {
+				options.ReferenceName = plumbing.NewBranchReferenceName(args[i])
+			}
+		default:
+			positional = append(positional, args[i])
+		}
+	}
+
+	if len(positional) != 2 {
+		return errors.New("clone expects a repository and a directory")
+	}
+
+	auth, err := r.auth(positional[0])
+	if err != nil {
+		return err
+	}
+
+	options.URL = positional[0]
+	options.Auth = auth
+	options.InsecureSkipTLS = r.insecure
+
+	_, err = git.PlainClone(positional[1], false, options)
+	return err
+}
+
+func (r *GoGitRunner) remote(dir string, args []string) error {
+	repository, err := git.PlainOpen(dir)
+	if err != nil {
+		return err
+	}
+
+	if len(args) == 3 && args[0] == "add" {
+		_, err = repository.CreateRemote(&config.RemoteConfig{Name: args[1], URLs: []string{args[2]}})
+		return err
+	}
+
+	if len(args) == 1 && args[0] == "update" {
+		remotes, err := repository.Remotes()
+		if err != nil {
+			return err
+		}
+		for _, remote := range remotes {
+			auth, err := r.auth(remote.Config().URLs[0])
+			if err != nil {
+				return err
+			}
+			err = remote.Fetch(&git.FetchOptions{Auth: auth, InsecureSkipTLS: r.insecure})
+			if err != nil && err != git.NoErrAlreadyUpToDate {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return fmt.Errorf("unsupported remote command for go-git backend: %s", strings.Join(args, " "))
+}
+
+// merge performs a three-way merge of the given revision into the worktree and the index without committing,
+// like git merge --no-ff --no-commit does. Files modified on both sides are merged line by line, overlapping
+// changes being reported as conflicts.
+func (r *GoGitRunner) merge(dir string, args []string) error {
+	var revision string
+	commit := true
+	for _, arg := range args {
+		switch arg {
+		case "--no-ff":
+		case "--no-commit":
+			commit = false
+		default:
+			revision = arg
+		}
+	}
+
+	if commit {
+		return errors.New("go-git backend only supports merge with --no-commit")
+	}
+
+	repository, err := git.PlainOpen(dir)
+	if err != nil {
+		return err
+	}
+
+	hash, err := repository.ResolveRevision(plumbing.Revision(revision))
+	if err != nil {
+		return err
+	}
+
+	theirs, err := repository.CommitObject(*hash)
+	if err != nil {
+		return err
+	}
+
+	head, err := repository.Head()
+	if err != nil {
+		return err
+	}
+
+	ours, err := repository.CommitObject(head.Hash())
+	if err != nil {
+		return err
+	}
+
+	bases, err := ours.MergeBase(theirs)
+	if err != nil {
+		return err
+	}
+
+	if len(bases) == 0 {
+		return errors.New("refusing to merge unrelated histories")
+	}
+
+	if bases[0].Hash == theirs.Hash {
+		return nil // already up to date
+	}
+
+	base, err := treeEntries(bases[0])
+	if err != nil {
+		return err
+	}
+
+	left, err := treeEntries(ours)
+	if err != nil {
+		return err
+	}
+
+	right, err := treeEntries(theirs)
+	if err != nil {
+		return err
+	}
+
+	changes, err := mergeEntries(repository, base, left, right)
+	if err != nil {
+		return err
+	}
+
+	worktree, err := repository.Worktree()
+	if err != nil {
+		return err
+	}
+
+	idx, err := repository.Storer.Index()
+	if err != nil {
+		return err
+	}
+
+	root := worktree.Filesystem.Root()
+	for name, entry := range changes {
+		err = os.RemoveAll(filepath.Join(root, name))
+		if err != nil {
+			return err
+		}
+
+		if entry == nil {
+			_, _ = idx.Remove(name)
+			continue
+		}
+
+		err = writeEntry(repository, root, name, entry)
+		if err != nil {
+			return err
+		}
+
+		e, err := idx.Entry(name)
+		if err != nil {
+			e = idx.Add(name)
+		}
+		e.Hash = entry.Hash
+		e.Mode = entry.Mode
+		e.ModifiedAt = time.Now()
+	}
+
+	err = repository.Storer.SetIndex(idx)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(root, ".git", "MERGE_HEAD"), []byte(theirs.Hash.String()+"\n"), 0644)
+}
+
+func (r *GoGitRunner) submodule(dir string, args []string) error {
+	options := &git.SubmoduleUpdateOptions{RecurseSubmodules: git.NoRecurseSubmodules}
+	update, recursive := false, false
+	var paths []string
+
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "update":
+			update = true
+		case "--init":
+			options.Init = true
+		case "--recursive":
+			recursive = true
+		case "--depth":
+			i++
+			if i == len(args) {
+				return errors.New("missing submodule depth")
+			}
+			depth, err := strconv.Atoi(args[i])
+			if err != nil {
+				return err
+			}
+			options.Depth = depth
+		case "--":
+			paths = args[i+1:]
+			i = len(args)
+		default:
+			return fmt.Errorf("unsupported submodule argument for go-git backend: %s", args[i])
+		}
+	}
+
+	if !update {
+		return fmt.Errorf("unsupported submodule command for go-git backend: %s", strings.Join(args, " "))
+	}
+
+	repository, err := git.PlainOpen(dir)
+	if err != nil {
+		return err
+	}
+
+	return r.updateSubmodules(repository, options, recursive, paths)
+}
+
+// updateSubmodules updates the submodules of a repository one level at a time, so that the url rewrites apply
+// to the nested submodules as well.
+func (r *GoGitRunner) updateSubmodules(repository *git.Repository, options *git.SubmoduleUpdateOptions, recursive bool, paths []string) error {
+	worktree, err := repository.Worktree()
+	if err != nil {
+		return err
+	}
+
+	submodules, err := worktree.Submodules()
+	if err != nil {
+		return err
+	}
+
+	for _, submodule := range submodules {
+		if len(paths) > 0 && !containsPath(paths, submodule.Config().Path) {
+			continue
+		}
+
+		url, err := submoduleURL(repository, submodule.Config().URL)
+		if err != nil {
+			return err
+		}
+
+		submodule.Config().URL = r.rewriteURL(url)
+		err = r.fetchSubmodule(repository, submodule, options)
+		if err != nil {
+			return err
+		}
+
+		err = submodule.Update(&git.SubmoduleUpdateOptions{NoFetch: true, RecurseSubmodules: git.NoRecurseSubmodules})
+		if err != nil {
+			return err
+		}
+
+		if recursive {
+			nested, err := submodule.Repository()
+			if err != nil {
+				return err
+			}
+			err = r.updateSubmodules(nested, options, recursive, nil)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// fetchSubmodule initializes the submodule if asked and fetches its commit like go-git does on update, with the
+// authentication and the tls verification of the runner which go-git does not forward.
+func (r *GoGitRunner) fetchSubmodule(repository *git.Repository, submodule *git.Submodule, options *git.SubmoduleUpdateOptions) error {
+	if options.Init {
+		err := submodule.Init()
+		if err != nil && err != git.ErrSubmoduleAlreadyInitialized {
+			return err
+		}
+	}
+
+	idx, err := repository.Storer.Index()
+	if err != nil {
+		return err
+	}
+
+	entry, err := idx.Entry(submodule.Config().Path)
+	if err != nil {
+		return err
+	}
+
+	nested, err := submodule.Repository()
+	if err != nil {
+		return err
+	}
+
+	auth, err := r.auth(submodule.Config().URL)
+	if err != nil {
+		return err
+	}
+
+	fetch := &git.FetchOptions{Auth: auth, Depth: options.Depth, InsecureSkipTLS: r.insecure}
+	err = nested.Fetch(fetch)
+	if err != nil && err != git.NoErrAlreadyUpToDate {
+		return err
+	}
+
+	// the commit may only be reachable by its sha
+	if _, err = nested.Object(plumbing.AnyObject, entry.Hash); err != nil {
+		fetch.RefSpecs = []config.RefSpec{config.RefSpec("+" + entry.Hash.String() + ":" + entry.Hash.String())}
+		err = nested.Fetch(fetch)
+		if err != nil && err != git.NoErrAlreadyUpToDate && err != git.ErrExactSHA1NotSupported {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// auth returns the authentication of go-git to the remote url, nil leaving go-git to its defaults. With an ssh
+// command configured, ssh urls authenticate against the running ssh-agent with its host key verification options.
+func (r *GoGitRunner) auth(url string) (transport.AuthMethod, error) {
+	if r.sshCommand == "" {
+		return nil, nil
+	}
+
+	endpoint, err := transport.NewEndpoint(url)
+	if err != nil {
+		return nil, err
+	}
+	if endpoint.Protocol != "ssh" {
+		return nil, nil
+	}
+
+	auth, err := ssh.NewSSHAgentAuth(endpoint.User)
+	if err != nil {
+		return nil, err
+	}
+
+	strict, file := sshHostKeyOptions(r.sshCommand)
+	auth.HostKeyCallback, err = hostKeyCallback(strict, file)
+	if err != nil {
+		return nil, err
+	}
+	return auth, nil
+}
+
+// submoduleURL resolves a submodule url relative to the superproject, like ../library.git, against the url of its
+// target remote, or of its origin remote for the nested submodules. go-git would pick any remote instead.
+func submoduleURL(repository *git.Repository, url string) (string, error) {
+	if !strings.HasPrefix(url, "./") && !strings.HasPrefix(url, "../") {
+		return url, nil
+	}
+
+	remote, err := repository.Remote("target")
+	if err == git.ErrRemoteNotFound {
+		remote, err = repository.Remote(git.DefaultRemoteName)
+	}
+	if err != nil {
+		return "", fmt.Errorf("resolving relative submodule url %s: %w", url, err)
+	}
+
+	endpoint, err := transport.NewEndpoint(remote.Config().URLs[0])
+	if err != nil {
+		return "", err
+	}
+
+	endpoint.Path = path.Join(endpoint.Path, url)
+	return endpoint.String(), nil
+}
+
+// rewriteURL applies the longest matching url rewrite, like git does with url.<base>.insteadOf.
+func (r *GoGitRunner) rewriteURL(u string) string {
+	prefix := ""
+	for p := range r.insteadOf {
+		if strings.HasPrefix(u, p) && len(p) > len(prefix) {
+			prefix = p
+		}
+	}
+	if prefix == "" {
+		return u
+	}
+	return r.insteadOf[prefix] + strings.TrimPrefix(u, prefix)
+}
+
+func containsPath(paths []string, path string) bool {
+	for _, p := range paths {
+		if strings.TrimSuffix(p, "/") == path {
+			return true
+		}
+	}
+	return false
+}
+
+// treeEntries lists the files and submodules of a commit by path.
+func treeEntries(commit *object.Commit) (map[string]*object.TreeEntry, error) {
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make(map[string]*object.TreeEntry)
+	walker := object.NewTreeWalker(tree, true, nil)
+	defer walker.Close()
+
+	for {
+		name, entry, err := walker.Next()
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if entry.Mode != filemode.Dir {
+			e := entry
+			entries[name] = &e
+		}
+	}
+}
+
+// mergeEntries returns the entries that differ between ours and the merge result, a nil entry meaning a deletion.
+// The files modified on both sides are merged into new blobs.
+func mergeEntries(repository *git.Repository, base, ours, theirs map[string]*object.TreeEntry) (map[string]*object.TreeEntry, error) {
+	names := make(map[string]bool)
+	for _, entries := range []map[string]*object.TreeEntry{base, ours, theirs} {
+		for name := range entries {
+			names[name] = true
+		}
+	}
+
+	changes := make(map[string]*object.TreeEntry)
+	var conflicts []string
+
+	for name := range names {
+		b, o, t := base[name], ours[name], theirs[name]
+		switch {
+		case sameEntry(o, t), sameEntry(b, t):
+			// nothing changed on their side
+		case sameEntry(b, o):
+			changes[name] = t
+		default:
+			merged, ok, err := mergeBlobs(repository, b, o, t)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				conflicts = append(conflicts, name)
+				continue
+			}
+			changes[name] = merged
+		}
+	}
+
+	if len(conflicts) > 0 {
+		sort.Strings(conflicts)
+		return nil, fmt.Errorf("merge conflict in %s", strings.Join(conflicts, ", "))
+	}
+
+	return changes, nil
+}
+
+func sameEntry(a, b *object.TreeEntry) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Hash == b.Hash && a.Mode == b.Mode
+}
+
+// mergeBlobs merges the changes of two sides to the same text file into a new blob. It is not ok when the file
+// is added, removed, binary or not a regular file on a side, or when the changes overlap.
+func mergeBlobs(repository *git.Repository, base, ours, theirs *object.TreeEntry) (*object.TreeEntry, bool, error) {
+	for _, entry := range []*object.TreeEntry{base, ours, theirs} {
+		if entry == nil || !entry.Mode.IsFile() || entry.Mode == filemode.Symlink {
+			return nil, false, nil
+		}
+	}
+
+	mode := ours.Mode
+	switch {
+	case ours.Mode == theirs.Mode, base.Mode == theirs.Mode:
+	case base.Mode == ours.Mode:
+		mode = theirs.Mode
+	default:
+		return nil, false, nil
+	}
+
+	var contents []string
+	for _, entry := range []*object.TreeEntry{base, ours, theirs} {
+		content, err := blobContent(repository, entry.Hash)
+		if err != nil {
+			return nil, false, err
+		}
+		if strings.ContainsRune(content, 0) {
+			return nil, false, nil
+		}
+		contents = append(contents, content)
+	}
+
+	merged, ok := mergeLines(contents[0], contents[1], contents[2])
+	if !ok {
+		return nil, false, nil
+	}
+
+	blob := repository.Storer.NewEncodedObject()
+	blob.SetType(plumbing.BlobObject)
+	writer, err := blob.Writer()
+	if err != nil {
+		return nil, false, err
+	}
+	_, err = io.WriteString(writer, merged)
+	if err != nil {
+		return nil, false, err
+	}
+	err = writer.Close()
+	if err != nil {
+		return nil, false, err
+	}
+
+	hash, err := repository.Storer.SetEncodedObject(blob)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return &object.TreeEntry{Name: ours.Name, Mode: mode, Hash: hash}, true, nil
+}
+
+func blobContent(repository *git.Repository, hash plumbing.Hash) (string, error) {
+	blob, err := repository.BlobObject(hash)
+	if err != nil {
+		return "", err
+	}
+
+	reader, err := blob.Reader()
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+
+	content, err := io.ReadAll(reader)
+	return string(content), err
+}
+
+// hunk replaces the base lines from start to end, excluded, with lines.
+type hunk struct {
+	start, end int
+	lines      []string
+}
+
+func (h hunk) same(other hunk) bool {
+	return h.start == other.start && h.end == other.end && strings.Join(h.lines, "") == strings.Join(other.lines, "")
+}
+
+// mergeLines applies the line changes of ours and theirs to base. It is not ok when changes of both sides overlap
+// or touch each other, unless they are the same, like git does.
+func mergeLines(base, ours, theirs string) (string, bool) {
+	lines := splitLines(base)
+	left, right := lineHunks(base, ours), lineHunks(base, theirs)
+
+	var merged strings.Builder
+	line := 0
+	for len(left) > 0 || len(right) > 0 {
+		var h hunk
+		switch {
+		case len(right) == 0 || len(left) > 0 && left[0].end < right[0].start:
+			h, left = left[0], left[1:]
+		case len(left) == 0 || right[0].end < left[0].start:
+			h, right = right[0], right[1:]
+		case left[0].same(right[0]):
+			h, left, right = left[0], left[1:], right[1:]
+		default:
+			return "", false
+		}
+
+		merged.WriteString(strings.Join(lines[line:h.start], ""))
+		merged.WriteString(strings.Join(h.lines, ""))
+		line = h.end
+	}
+	merged.WriteString(strings.Join(lines[line:], ""))
+
+	return merged.String(), true
+}
+
+// lineHunks returns the changes turning base into other, in order.
+func lineHunks(base, other string) []hunk {
+	var hunks []hunk
+	line, changed := 0, false
+	for _, d := range diff.Do(base, other) {
+		lines := splitLines(d.Text)
+		if d.Type == diffmatchpatch.DiffEqual {
+			line += len(lines)
+			changed = false
+			continue
+		}
+
+		if !changed {
+			hunks = append(hunks, hunk{start: line, end: line})
+			changed = true
+		}
+
+		h := &hunks[len(hunks)-1]
+		if d.Type == diffmatchpatch.DiffDelete {
+			line += len(lines)
+			h.end = line
+		} else {
+			h.lines = append(h.lines, lines...)
+		}
+	}
+	return hunks
+}
+
+// splitLines splits text after each line feed, the last line may not end with one.
+func splitLines(text string) []string {
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+func writeEntry(repository *git.Repository, root string, name string, entry *object.TreeEntry) error {
+	target := filepath.Join(root, name)
+	err := os.MkdirAll(filepath.Dir(target), 0755)
+	if err != nil {
+		return err
+	}
+
+	if entry.Mode == filemode.Submodule {
+		return os.MkdirAll(target, 0755)
+	}
+
+	blob, err := repository.BlobObject(entry.Hash)
+	if err != nil {
+		return err
+	}
+
+	reader, err := blob.Reader()
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	if entry.Mode == filemode.Symlink {
+		link, err := io.ReadAll(reader)
+		if err != nil {
+			return err
+		}
+		return os.Symlink(string(link), target)
+	}
+
+	perm := os.FileMode(0644)
+	if entry.Mode == filemode.Executable {
+		perm = 0755
+	}
+
+	file, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = io.Copy(file, reader)
+	return err
+}
+
+// sshHostKeyOptions returns the StrictHostKeyChecking and UserKnownHostsFile options of an ssh command.
+func sshHostKeyOptions(command string) (strict string, file string) {
+	strict = "yes"
+	fields := strings.Fields(command)
+	for i := 0; i < len(fields)-1; i++ {
+		if fields[i] != "-o" {
+			continue
+		}
+		key, value, _ := strings.Cut(fields[i+1], "=")
+		switch key {
+		case "StrictHostKeyChecking":
+			strict = value
+		case "UserKnownHostsFile":
+			file = value
+		}
+	}
+
+	return strict, file
+}
+
+func hostKeyCallback(strict string, file string) (gossh.HostKeyCallback, error) {
+	if strict == "no" {
+		return gossh.InsecureIgnoreHostKey(), nil
+	}
+
+	var files []string
+	if file != "" {
+		files = append(files, file)
+	}
+
+	callback, err := ssh.NewKnownHostsCallback(files...)
+	if err != nil || strict != "accept-new" {
+		return callback, err
+	}
+
+	if file == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		file = filepath.Join(home, ".ssh", "known_hosts")
+	}
+
+	return func(hostname string, remote net.Addr, key gossh.PublicKey) error {
+		err := callback(hostname, remote, key)
+		var keyErr *knownhosts.KeyError
+		if !errors.As(err, &keyErr) || len(keyErr.Want) > 0 {
+			return err
+		}
+
+		// unknown host, remember its key
+		known, err := os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			return err
+		}
+		defer known.Close()
+
+		_, err = fmt.Fprintln(known, knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key))
+		return err
+	}, nil
+}
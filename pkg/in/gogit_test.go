@@ -0,0 +1,380 @@
+package in_test
+
+import (
+	"encoding/json"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/format/pktline"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/server"
+	"github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/samcontesse/gitlab-merge-request-resource/pkg"
+	"github.com/samcontesse/gitlab-merge-request-resource/pkg/in"
+	"github.com/xanzy/go-gitlab"
+)
+
+var _ = Describe("GoGitRunner", func() {
+
+	var (
+		mux         *http.ServeMux
+		server      *httptest.Server
+		command     *in.Command
+		root        *url.URL
+		fixtures    string
+		destination string
+		work        string
+		target      plumbing.Hash
+		source      plumbing.Hash
+		library     plumbing.Hash
+		sourceID    int
+	)
+
+	BeforeEach(func() {
+		fixtures, _ = os.MkdirTemp("", "gitlab-merge-request-resource-fixtures")
+		work, _ = os.MkdirTemp("", "gitlab-merge-request-resource-work")
+		destination = filepath.Join(fixtures, "destination")
+		libraryWork, _ := os.MkdirTemp(work, "library")
+		_, commitLibrary := initFixtureRepository(filepath.Join(fixtures, "namespace", "library.git"), libraryWork)
+		library = commitLibrary("library", map[string]string{"lib.txt": "library"})
+		Expect(os.MkdirAll(filepath.Join(work, "project"), 0755)).To(Succeed())
+		target, source = createFixtureRepository(filepath.Join(fixtures, "namespace", "project.git"), filepath.Join(work, "project"), library)
+		sourceID = 42
+
+		mux = http.NewServeMux()
+		server = httptest.NewServer(mux)
+		root, _ = url.Parse(server.URL)
+		serveRepositories(mux, fixtures)
+
+		context, _ := url.Parse("/api/v4")
+		client, _ := gitlab.NewClient("$", gitlab.WithBaseURL(root.ResolveReference(context).String()))
+		command = in.NewCommand(client).WithRunner(in.NewGoGitRunner())
+
+		mux.HandleFunc("/api/v4/projects/namespace/project/merge_requests/1", func(w http.ResponseWriter, r *http.Request) {
+			mr := gitlab.MergeRequest{
+				IID:             88,
+				ID:              99,
+				SHA:             source.String(),
+				ProjectID:       42,
+				TargetProjectID: 42,
+				SourceProjectID: sourceID,
+				SourceBranch:    "source-branch",
+				TargetBranch:    "target-branch",
+				Author:          &gitlab.BasicUser{Name: "Tester"},
+			}
+			writeJSON(w, mr)
+		})
+		mux.HandleFunc("/api/v4/projects/42", func(w http.ResponseWriter, r *http.Request) {
+			project, _ := url.Parse("namespace/project.git")
+			writeJSON(w, gitlab.Project{HTTPURLToRepo: root.ResolveReference(project).String()})
+		})
+		mux.HandleFunc("/api/v4/projects/42/repository/commits/"+source.String(), func(w http.ResponseWriter, r *http.Request) {
+			writeJSON(w, gitlab.Commit{Title: "change on source"})
+		})
+		mux.HandleFunc("/api/v4/projects/42/merge_requests/88/versions", func(w http.ResponseWriter, r *http.Request) {
+			writeJSON(w, []gitlab.MergeRequestDiffVersion{})
+		})
+		mux.HandleFunc("/api/v4/user", func(w http.ResponseWriter, r *http.Request) {
+			writeJSON(w, gitlab.User{Username: "test", Email: "test@example.com"})
+		})
+	})
+
+	AfterEach(func() {
+		server.Close()
+		os.RemoveAll(fixtures)
+		os.RemoveAll(work)
+	})
+
+	It("Should clone the target branch and merge the merge request without committing", func() {
+		project, _ := url.Parse("namespace/project.git")
+
+		request := in.Request{
+			Source: pkg.Source{
+				URI:          root.ResolveReference(project).String(),
+				PrivateToken: "$",
+			},
+			Version: pkg.Version{ID: 1},
+		}
+
+		response, err := command.Run(destination, request)
+		Expect(err).Should(BeNil())
+		Expect(response.Metadata[0].Value).To(Equal("99"))
+
+		repository, err := git.PlainOpen(destination)
+		Expect(err).Should(BeNil())
+		head, _ := repository.Head()
+		Expect(head.Hash()).To(Equal(target))
+		cfg, _ := repository.Config()
+		Expect(cfg.User.Email).To(Equal("test@example.com"))
+
+		content, _ := os.ReadFile(filepath.Join(destination, "target.txt"))
+		Expect(string(content)).To(Equal("target"))
+		content, _ = os.ReadFile(filepath.Join(destination, "source.txt"))
+		Expect(string(content)).To(Equal("source"))
+		_, err = os.Stat(filepath.Join(destination, "removed.txt"))
+		Expect(os.IsNotExist(err)).To(BeTrue())
+		content, _ = os.ReadFile(filepath.Join(destination, "shared.txt"))
+		Expect(string(content)).To(Equal("ONE\ntwo\nthree\nfour\nFIVE\n"))
+
+		worktree, _ := repository.Worktree()
+		status, _ := worktree.Status()
+		Expect(status.File("shared.txt").Staging).To(Equal(git.Modified))
+
+		mergeHead, _ := os.ReadFile(filepath.Join(destination, ".git", "MERGE_HEAD"))
+		Expect(string(mergeHead)).To(Equal(source.String() + "\n"))
+	})
+
+	It("Should report overlapping changes to a file as a conflict", func() {
+		commitFixture(filepath.Join(fixtures, "namespace", "project.git"), filepath.Join(work, "project"), "target-branch", map[string]string{"shared.txt": "ONE\ntwo\nthree\nfour\nfive!\n"})
+		project, _ := url.Parse("namespace/project.git")
+
+		request := in.Request{
+			Source: pkg.Source{
+				URI:          root.ResolveReference(project).String(),
+				PrivateToken: "$",
+			},
+			Version: pkg.Version{ID: 1},
+		}
+
+		_, err := command.Run(destination, request)
+		Expect(err).To(MatchError("merge conflict in shared.txt"))
+	})
+
+	It("Should fetch same server submodules through authenticated https", func() {
+		project, _ := url.Parse("namespace/project.git")
+
+		request := in.Request{
+			Source: pkg.Source{
+				URI:            root.ResolveReference(project).String(),
+				PrivateToken:   "$",
+				Recursive:      true,
+				SubmodulePaths: []string{"libs/library"},
+			},
+			Version: pkg.Version{ID: 1},
+		}
+
+		_, err := command.Run(destination, request)
+		Expect(err).Should(BeNil())
+
+		content, _ := os.ReadFile(filepath.Join(destination, "libs", "library", "lib.txt"))
+		Expect(string(content)).To(Equal("library"))
+	})
+
+	It("Should resolve relative submodule urls against the target remote", func() {
+		commitFixture(filepath.Join(fixtures, "namespace", "project.git"), filepath.Join(work, "project"), "target-branch", map[string]string{".gitmodules": "[submodule \"libs/library\"]\n\tpath = libs/library\n\turl = ../library.git\n"})
+
+		// the fork has no library next to it
+		copyDir(filepath.Join(fixtures, "namespace", "project.git"), filepath.Join(fixtures, "fork", "project.git"))
+		sourceID = 43
+		mux.HandleFunc("/api/v4/projects/43", func(w http.ResponseWriter, r *http.Request) {
+			project, _ := url.Parse("fork/project.git")
+			writeJSON(w, gitlab.Project{HTTPURLToRepo: root.ResolveReference(project).String()})
+		})
+		mux.HandleFunc("/api/v4/projects/43/repository/commits/"+source.String(), func(w http.ResponseWriter, r *http.Request) {
+			writeJSON(w, gitlab.Commit{Title: "change on source"})
+		})
+
+		project, _ := url.Parse("namespace/project.git")
+		request := in.Request{
+			Source: pkg.Source{
+				URI:          root.ResolveReference(project).String(),
+				PrivateToken: "$",
+				Recursive:    true,
+			},
+			Version: pkg.Version{ID: 1},
+		}
+
+		_, err := command.Run(destination, request)
+		Expect(err).Should(BeNil())
+
+		repository, _ := git.PlainOpen(destination)
+		remotes, _ := repository.Remotes()
+		Expect(remotes).To(HaveLen(2))
+
+		content, _ := os.ReadFile(filepath.Join(destination, "libs", "library", "lib.txt"))
+		Expect(string(content)).To(Equal("library"))
+	})
+
+	It("Should fetch submodules over self-signed https when insecure", func() {
+		server.Close()
+		server = httptest.NewTLSServer(mux)
+		root, _ = url.Parse(server.URL)
+		context, _ := url.Parse("/api/v4")
+		client, _ := gitlab.NewClient("$", gitlab.WithBaseURL(root.ResolveReference(context).String()), gitlab.WithHTTPClient(server.Client()))
+		command = in.NewCommand(client).WithRunner(in.NewGoGitRunner())
+
+		project, _ := url.Parse("namespace/project.git")
+		request := in.Request{
+			Source: pkg.Source{
+				URI:          root.ResolveReference(project).String(),
+				PrivateToken: "$",
+				Insecure:     true,
+				Recursive:    true,
+			},
+			Version: pkg.Version{ID: 1},
+		}
+
+		_, err := command.Run(destination, request)
+		Expect(err).Should(BeNil())
+
+		content, _ := os.ReadFile(filepath.Join(destination, "libs", "library", "lib.txt"))
+		Expect(string(content)).To(Equal("library"))
+	})
+
+	It("Should keep the ssh configuration to the runner", func() {
+		builder := reflect.ValueOf(ssh.DefaultAuthBuilder).Pointer()
+		Expect(git.PlainInit(destination, false)).Error().Should(BeNil())
+
+		err := in.NewGoGitRunner().Run(destination, "-c", "core.sshCommand=ssh -o StrictHostKeyChecking=no", "config", "user.name", "Tester")
+		Expect(err).Should(BeNil())
+		Expect(reflect.ValueOf(ssh.DefaultAuthBuilder).Pointer()).To(Equal(builder))
+	})
+
+})
+
+// createFixtureRepository creates a repository in dir with a target-branch and a diverging source-branch.
+// The base commit references the library submodule with an ssh url when a library commit is given.
+func createFixtureRepository(dir string, work string, library plumbing.Hash) (plumbing.Hash, plumbing.Hash) {
+	repository, commit := initFixtureRepository(dir, work)
+	worktree, _ := repository.Worktree()
+
+	files := map[string]string{"README.md": "readme", "removed.txt": "removed", "shared.txt": "one\ntwo\nthree\nfour\nfive\n"}
+	if !library.IsZero() {
+		files[".gitmodules"] = "[submodule \"libs/library\"]\n\tpath = libs/library\n\turl = ssh://git@127.0.0.1/namespace/library.git\n"
+		idx, _ := repository.Storer.Index()
+		entry := idx.Add("libs/library")
+		entry.Hash = library
+		entry.Mode = filemode.Submodule
+		Expect(repository.Storer.SetIndex(idx)).To(Succeed())
+	}
+
+	base := commit("base", files)
+	Expect(repository.Storer.SetReference(plumbing.NewHashReference("refs/heads/target-branch", base))).To(Succeed())
+	Expect(repository.Storer.SetReference(plumbing.NewHashReference("refs/heads/source-branch", base))).To(Succeed())
+
+	Expect(worktree.Checkout(&git.CheckoutOptions{Branch: "refs/heads/target-branch", Force: true})).To(Succeed())
+	target := commit("change on target", map[string]string{"target.txt": "target", "shared.txt": "ONE\ntwo\nthree\nfour\nfive\n"})
+
+	Expect(worktree.Checkout(&git.CheckoutOptions{Branch: "refs/heads/source-branch", Force: true})).To(Succeed())
+	source := commit("change on source", map[string]string{"source.txt": "source", "removed.txt": "", "shared.txt": "one\ntwo\nthree\nfour\nFIVE\n"})
+
+	return target, source
+}
+
+// initFixtureRepository initializes a repository stored in dir with its worktree in work, and returns it along
+// with a function committing files to it, an empty content removing the file.
+func initFixtureRepository(dir string, work string) (*git.Repository, func(string, map[string]string) plumbing.Hash) {
+	storage := filesystem.NewStorage(osfs.New(dir), cache.NewObjectLRUDefault())
+	repository, err := git.Init(storage, osfs.New(work))
+	Expect(err).Should(BeNil())
+	return repository, fixtureCommitter(repository, work)
+}
+
+// commitFixture commits files on a branch of the repository stored in dir with its worktree in work.
+func commitFixture(dir string, work string, branch string, files map[string]string) plumbing.Hash {
+	storage := filesystem.NewStorage(osfs.New(dir), cache.NewObjectLRUDefault())
+	repository, err := git.Open(storage, osfs.New(work))
+	Expect(err).Should(BeNil())
+	worktree, _ := repository.Worktree()
+	Expect(worktree.Checkout(&git.CheckoutOptions{Branch: plumbing.NewBranchReferenceName(branch), Force: true})).To(Succeed())
+	return fixtureCommitter(repository, work)("change on "+branch, files)
+}
+
+func fixtureCommitter(repository *git.Repository, work string) func(string, map[string]string) plumbing.Hash {
+	worktree, _ := repository.Worktree()
+
+	return func(message string, files map[string]string) plumbing.Hash {
+		for name, content := range files {
+			if content == "" {
+				_, err := worktree.Remove(name)
+				Expect(err).Should(BeNil())
+				continue
+			}
+			Expect(os.WriteFile(filepath.Join(work, name), []byte(content), 0644)).To(Succeed())
+			_, err := worktree.Add(name)
+			Expect(err).Should(BeNil())
+		}
+		signature := &object.Signature{Name: "Tester", Email: "test@example.com", When: time.Now()}
+		hash, err := worktree.Commit(message, &git.CommitOptions{Author: signature})
+		Expect(err).Should(BeNil())
+		return hash
+	}
+}
+
+// serveRepositories serves the repositories found in dir with the git smart HTTP protocol.
+func serveRepositories(mux *http.ServeMux, dir string) {
+	srv := server.NewServer(server.NewFilesystemLoader(osfs.New(dir)))
+
+	session := func(r *http.Request, suffix string) transport.UploadPackSession {
+		ep, err := transport.NewEndpoint(r.URL.Path[:len(r.URL.Path)-len(suffix)])
+		Expect(err).Should(BeNil())
+		s, err := srv.NewUploadPackSession(ep, nil)
+		Expect(err).Should(BeNil())
+		return s
+	}
+
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/info/refs"):
+			refs, err := session(r, "/info/refs").AdvertisedReferencesContext(r.Context())
+			Expect(err).Should(BeNil())
+			refs.Prefix = [][]byte{[]byte("# service=git-upload-pack"), pktline.Flush}
+			w.Header().Set("content-type", "application/x-git-upload-pack-advertisement")
+			Expect(refs.Encode(w)).To(Succeed())
+		case strings.HasSuffix(r.URL.Path, "/git-upload-pack"):
+			request := packp.NewUploadPackRequest()
+			Expect(request.Decode(r.Body)).To(Succeed())
+			response, err := session(r, "/git-upload-pack").UploadPack(r.Context(), request)
+			Expect(err).Should(BeNil())
+			w.Header().Set("content-type", "application/x-git-upload-pack-result")
+			Expect(response.Encode(w)).To(Succeed())
+		default:
+			http.NotFound(w, r)
+		}
+	}
+	mux.HandleFunc("/namespace/", handler)
+	mux.HandleFunc("/fork/", handler)
+}
+
+// copyDir copies the files of the src directory to dst.
+func copyDir(src string, dst string) {
+	err := filepath.WalkDir(src, func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(src, name)
+		if entry.IsDir() {
+			return os.MkdirAll(filepath.Join(dst, rel), 0755)
+		}
+		content, err := os.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(filepath.Join(dst, rel), content, 0644)
+	})
+	Expect(err).Should(BeNil())
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	output, _ := json.Marshal(v)
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(output)
+}
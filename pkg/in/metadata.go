@@ -0,0 +1,86 @@
+package in
+
+import (
+	"fmt"
+	"github.com/xanzy/go-gitlab"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// writeMetadataFiles writes one file per merge request attribute into dir, along with a
+// merge-request.env file that can be sourced from a shell.
+func writeMetadataFiles(dir string, mr *gitlab.MergeRequest) error {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+
+	author := ""
+	if mr.Author != nil {
+		author = mr.Author.Username
+	}
+
+	labels := ""
+	if len(mr.Labels) > 0 {
+		labels = strings.Join(mr.Labels, "\n") + "\n"
+	}
+
+	files := []struct {
+		name    string
+		content string
+	}{
+		{"title", mr.Title},
+		{"description", mr.Description},
+		{"author_username", author},
+		{"labels", labels},
+		{"source_branch", mr.SourceBranch},
+		{"target_branch", mr.TargetBranch},
+		{"base_sha", mr.DiffRefs.BaseSha},
+		{"head_sha", mr.DiffRefs.HeadSha},
+		{"web_url", mr.WebURL},
+	}
+
+	for _, f := range files {
+		err = os.WriteFile(filepath.Join(dir, f.name), []byte(f.content), 0644)
+		if err != nil {
+			return err
+		}
+	}
+
+	vars := []struct {
+		name  string
+		value string
+	}{
+		{"MR_ID", strconv.Itoa(mr.ID)},
+		{"MR_IID", strconv.Itoa(mr.IID)},
+		{"MR_SHA", mr.SHA},
+		{"MR_TITLE", mr.Title},
+		{"MR_DESCRIPTION", mr.Description},
+		{"MR_AUTHOR_USERNAME", author},
+		{"MR_LABELS", strings.Join(mr.Labels, ",")},
+		{"MR_SOURCE_BRANCH", mr.SourceBranch},
+		{"MR_TARGET_BRANCH", mr.TargetBranch},
+		{"MR_BASE_SHA", mr.DiffRefs.BaseSha},
+		{"MR_HEAD_SHA", mr.DiffRefs.HeadSha},
+		{"MR_WEB_URL", mr.WebURL},
+		{"MR_DRAFT", strconv.FormatBool(isDraft(mr))},
+	}
+
+	var env strings.Builder
+	for _, v := range vars {
+		fmt.Fprintf(&env, "%s=%s\n", v.name, shellQuote(v.value))
+	}
+
+	return os.WriteFile(filepath.Join(dir, "merge-request.env"), []byte(env.String()), 0644)
+}
+
+// shellQuote wraps value in single quotes so that it is taken literally when sourced.
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+func isDraft(mr *gitlab.MergeRequest) bool {
+	return mr.Draft || mr.WorkInProgress
+}
@@ -7,9 +7,15 @@ import (
 type Request struct {
 	Source  Source  `json:"source"`
 	Version Version `json:"version"`
+	Params  Params  `json:"params"`
 }
 
 type Response struct {
 	Version  Version  `json:"version"`
 	Metadata Metadata `json:"metadata"`
 }
+
+type Params struct {
+	SkipClone bool     `json:"skip_clone,omitempty"`
+	Fetch     []string `json:"fetch,omitempty"`
+}
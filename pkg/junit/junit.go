@@ -0,0 +1,161 @@
+// Package junit summarises JUnit XML test reports.
+package junit
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strings"
+)
+
+// Report is the summary of one or several test reports.
+type Report struct {
+	Tests    int
+	Failures int
+	Skipped  int
+	Failed   []Failure
+}
+
+// Failure is a failed test case, or a test case in error, along with its message.
+type Failure struct {
+	Suite   string
+	Name    string
+	Message string
+}
+
+type testSuites struct {
+	Suites []testSuite `xml:"testsuite"`
+}
+
+type testSuite struct {
+	Name   string      `xml:"name,attr"`
+	Suites []testSuite `xml:"testsuite"`
+	Cases  []testCase  `xml:"testcase"`
+}
+
+type testCase struct {
+	Name      string   `xml:"name,attr"`
+	ClassName string   `xml:"classname,attr"`
+	Failure   *outcome `xml:"failure"`
+	Error     *outcome `xml:"error"`
+	Skipped   *outcome `xml:"skipped"`
+}
+
+type outcome struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// Passed returns the number of test cases neither failed nor skipped.
+func (report Report) Passed() int {
+	return report.Tests - report.Failures - report.Skipped
+}
+
+// Parse summarises a test report, either a testsuites or a single testsuite document.
+func Parse(content []byte) (Report, error) {
+	var root struct {
+		XMLName xml.Name
+		testSuite
+	}
+
+	err := xml.Unmarshal(content, &root)
+	if err != nil {
+		return Report{}, err
+	}
+
+	var report Report
+	switch root.XMLName.Local {
+	case "testsuites":
+		for _, suite := range root.Suites {
+			report.add(suite)
+		}
+	case "testsuite":
+		report.add(root.testSuite)
+	default:
+		return Report{}, fmt.Errorf("invalid junit report root element: %v", root.XMLName.Local)
+	}
+
+	return report, nil
+}
+
+// Merge adds the results of another report.
+func (report *Report) Merge(other Report) {
+	report.Tests += other.Tests
+	report.Failures += other.Failures
+	report.Skipped += other.Skipped
+	report.Failed = append(report.Failed, other.Failed...)
+}
+
+func (report *Report) add(suite testSuite) {
+	for _, nested := range suite.Suites {
+		report.add(nested)
+	}
+
+	for _, tc := range suite.Cases {
+		report.Tests++
+		result := tc.Failure
+		if result == nil {
+			result = tc.Error
+		}
+		switch {
+		case result != nil:
+			report.Failures++
+			name := suite.Name
+			if name == "" {
+				name = tc.ClassName
+			}
+			report.Failed = append(report.Failed, Failure{Suite: name, Name: tc.Name, Message: result.message()})
+		case tc.Skipped != nil:
+			report.Skipped++
+		}
+	}
+}
+
+// message returns the message attribute, or the first line of the text when it is missing.
+func (o outcome) message() string {
+	if o.Message != "" {
+		return o.Message
+	}
+	text := strings.TrimSpace(o.Text)
+	if i := strings.IndexByte(text, '\n'); i >= 0 {
+		text = text[:i]
+	}
+	return text
+}
+
+// Description returns a one line summary, e.g. "412 passed, 3 failed, 2 skipped".
+func (report Report) Description() string {
+	description := fmt.Sprintf("%d passed, %d failed", report.Passed(), report.Failures)
+	if report.Skipped > 0 {
+		description += fmt.Sprintf(", %d skipped", report.Skipped)
+	}
+	return description
+}
+
+// Markdown returns the summary followed by a table of at most limit failed tests.
+func (report Report) Markdown(limit int) string {
+	var summary strings.Builder
+	fmt.Fprintf(&summary, "**Test results**: %s (%d tests)\n", report.Description(), report.Tests)
+
+	if len(report.Failed) == 0 {
+		return summary.String()
+	}
+
+	summary.WriteString("\n| Failed test | Message |\n|---|---|\n")
+	for i, failure := range report.Failed {
+		if i == limit {
+			fmt.Fprintf(&summary, "\nand %d more failed tests\n", len(report.Failed)-limit)
+			break
+		}
+		name := failure.Name
+		if failure.Suite != "" {
+			name = failure.Suite + " / " + name
+		}
+		fmt.Fprintf(&summary, "| `%s` | %s |\n", escapeCell(name), escapeCell(failure.Message))
+	}
+
+	return summary.String()
+}
+
+func escapeCell(value string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(value, "|", "\\|"), "\n", " ")
+}
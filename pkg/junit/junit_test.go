@@ -0,0 +1,96 @@
+package junit
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    Report
+		wantErr bool
+	}{
+		{
+			name: "testsuites",
+			content: `<?xml version="1.0" encoding="UTF-8"?>
+<testsuites>
+  <testsuite name="pkg/a" tests="3">
+    <testcase name="TestOne" classname="pkg/a"/>
+    <testcase name="TestTwo" classname="pkg/a"><failure message="expected 1, got 2">a_test.go:12</failure></testcase>
+    <testcase name="TestThree" classname="pkg/a"><skipped/></testcase>
+  </testsuite>
+  <testsuite name="pkg/b">
+    <testcase name="TestFour"><error>panic: nil map
+goroutine 1</error></testcase>
+  </testsuite>
+</testsuites>`,
+			want: Report{
+				Tests:    4,
+				Failures: 2,
+				Skipped:  1,
+				Failed: []Failure{
+					{Suite: "pkg/a", Name: "TestTwo", Message: "expected 1, got 2"},
+					{Suite: "pkg/b", Name: "TestFour", Message: "panic: nil map"},
+				},
+			},
+		},
+		{
+			name: "single testsuite with nested suites",
+			content: `<testsuite name="root">
+  <testcase name="first"/>
+  <testsuite name="nested"><testcase name="second" classname="Nested"><failure>boom</failure></testcase></testsuite>
+</testsuite>`,
+			want: Report{
+				Tests:    2,
+				Failures: 1,
+				Failed:   []Failure{{Suite: "nested", Name: "second", Message: "boom"}},
+			},
+		},
+		{
+			name:    "not a junit report",
+			content: `<coverage line-rate="1"/>`,
+			wantErr: true,
+		},
+		{
+			name:    "invalid xml",
+			content: `<testsuite>`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse([]byte(tt.content))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMarkdown(t *testing.T) {
+	report := Report{Tests: 5, Failures: 3, Skipped: 1, Failed: []Failure{
+		{Suite: "pkg", Name: "TestA", Message: "a | b"},
+		{Name: "TestB", Message: "failed"},
+		{Name: "TestC", Message: "failed"},
+	}}
+
+	want := "**Test results**: 1 passed, 3 failed, 1 skipped (5 tests)\n" +
+		"\n| Failed test | Message |\n|---|---|\n" +
+		"| `pkg / TestA` | a \\| b |\n" +
+		"| `TestB` | failed |\n" +
+		"\nand 1 more failed tests\n"
+
+	if got := report.Markdown(2); got != want {
+		t.Errorf("Markdown() = %q, want %q", got, want)
+	}
+
+	if got := (Report{Tests: 2}).Markdown(10); got != "**Test results**: 2 passed, 0 failed (2 tests)\n" {
+		t.Errorf("Markdown() = %q", got)
+	}
+}
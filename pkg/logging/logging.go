@@ -0,0 +1,298 @@
+// Package logging writes leveled log entries to stderr, as text or JSON lines, with the secrets redacted.
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+var levelNames = map[Level]string{
+	LevelDebug: "debug",
+	LevelInfo:  "info",
+	LevelWarn:  "warn",
+	LevelError: "error",
+}
+
+func (level Level) String() string {
+	return levelNames[level]
+}
+
+// ParseLevel returns the level of the given name, info by default.
+func ParseLevel(name string) (Level, error) {
+	if name == "" {
+		return LevelInfo, nil
+	}
+	for level, n := range levelNames {
+		if strings.EqualFold(n, name) {
+			return level, nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("invalid value for log_level: %v", name)
+}
+
+// redacted is written in place of the secrets.
+const redacted = "[REDACTED]"
+
+// secretPatterns match the credentials which are redacted even when they were not registered: personal access
+// tokens, passwords of urls and private token headers.
+var secretPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`gl[a-z]{2,5}-[0-9A-Za-z_\-]{20,}`),
+	regexp.MustCompile(`(://[^/\s:@]+:)[^/\s@]+(@)`),
+	regexp.MustCompile(`(?i)(private[-_]token\\?["']?\s*[:=]\s*\\?["']?)[^\s"'\\,&]+`),
+}
+
+// Logger writes the entries at or above its level. The zero value is not usable, see New.
+type Logger struct {
+	mu      *sync.Mutex
+	out     io.Writer
+	level   Level
+	format  string
+	secrets *[]string
+	fields  []interface{}
+	now     func() time.Time
+}
+
+// New returns a logger writing to out the entries at or above the named level, in the named format.
+func New(out io.Writer, level string, format string) (*Logger, error) {
+	parsed, err := ParseLevel(level)
+	if err != nil {
+		return nil, err
+	}
+
+	switch strings.ToLower(format) {
+	case "":
+		format = FormatText
+	case FormatText, FormatJSON:
+		format = strings.ToLower(format)
+	default:
+		return nil, fmt.Errorf("invalid value for log_format: %v", format)
+	}
+
+	return &Logger{mu: &sync.Mutex{}, out: out, level: parsed, format: format, secrets: &[]string{}, now: time.Now}, nil
+}
+
+var defaultLogger, _ = New(os.Stderr, "", "")
+
+// Default returns the logger used by the commands unless another one is given.
+func Default() *Logger {
+	return defaultLogger
+}
+
+// SetDefault replaces the default logger.
+func SetDefault(logger *Logger) {
+	defaultLogger = logger
+}
+
+// Discard returns a logger writing nothing.
+func Discard() *Logger {
+	logger, _ := New(io.Discard, "error", "")
+	logger.level = LevelError + 1
+	return logger
+}
+
+// Redact registers secrets to remove from the entries of the logger and of the loggers derived from it.
+func (logger *Logger) Redact(secrets ...string) *Logger {
+	logger.mu.Lock()
+	defer logger.mu.Unlock()
+	for _, secret := range secrets {
+		if secret != "" {
+			*logger.secrets = append(*logger.secrets, secret)
+		}
+	}
+	return logger
+}
+
+// With returns a logger adding the key value pairs to its entries.
+func (logger *Logger) With(keyvals ...interface{}) *Logger {
+	derived := *logger
+	derived.fields = append(append([]interface{}{}, logger.fields...), keyvals...)
+	return &derived
+}
+
+// Enabled tells whether the entries of the level are written.
+func (logger *Logger) Enabled(level Level) bool {
+	return level >= logger.level
+}
+
+func (logger *Logger) Debug(msg string, keyvals ...interface{}) { logger.log(LevelDebug, msg, keyvals) }
+func (logger *Logger) Info(msg string, keyvals ...interface{})  { logger.log(LevelInfo, msg, keyvals) }
+func (logger *Logger) Warn(msg string, keyvals ...interface{})  { logger.log(LevelWarn, msg, keyvals) }
+func (logger *Logger) Error(msg string, keyvals ...interface{}) { logger.log(LevelError, msg, keyvals) }
+
+func (logger *Logger) log(level Level, msg string, keyvals []interface{}) {
+	if !logger.Enabled(level) {
+		return
+	}
+
+	keyvals = append(append([]interface{}{}, logger.fields...), keyvals...)
+
+	// the values are redacted before being formatted, which escapes the multi-line secrets such as ssh keys
+	redact := logger.redactor()
+	msg = redact(msg)
+	for i := 0; i < len(keyvals); i += 2 {
+		j := i + 1
+		if i == len(keyvals)-1 {
+			j = i
+		}
+		switch keyvals[j].(type) {
+		case string, error, fmt.Stringer:
+			keyvals[j] = redact(fmt.Sprint(keyvals[j]))
+		}
+	}
+
+	var line string
+	if logger.format == FormatJSON {
+		line = logger.formatJSON(level, msg, keyvals)
+	} else {
+		line = logger.formatText(level, msg, keyvals)
+	}
+
+	line = redact(line)
+
+	logger.mu.Lock()
+	defer logger.mu.Unlock()
+	io.WriteString(logger.out, line+"\n")
+}
+
+func (logger *Logger) formatText(level Level, msg string, keyvals []interface{}) string {
+	var line strings.Builder
+	fmt.Fprintf(&line, "time=%s level=%s msg=%s", logger.now().Format(time.RFC3339), level, quote(msg))
+	for i := 0; i < len(keyvals); i += 2 {
+		fmt.Fprintf(&line, " %s=%s", key(keyvals, i), quote(value(keyvals, i)))
+	}
+	return line.String()
+}
+
+func (logger *Logger) formatJSON(level Level, msg string, keyvals []interface{}) string {
+	entry := map[string]interface{}{
+		"time":  logger.now().Format(time.RFC3339),
+		"level": level.String(),
+		"msg":   msg,
+	}
+	for i := 0; i < len(keyvals); i += 2 {
+		var v interface{} = value(keyvals, i)
+		if i+1 < len(keyvals) {
+			switch keyvals[i+1].(type) {
+			case error, fmt.Stringer:
+			default:
+				v = keyvals[i+1]
+			}
+		}
+		entry[key(keyvals, i)] = v
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Sprintf(`{"level":"error","msg":"encoding log entry: %s"}`, err)
+	}
+	return string(line)
+}
+
+// redactor returns a function removing the registered secrets, longest first, and the credentials matching the
+// patterns.
+func (logger *Logger) redactor() func(string) string {
+	logger.mu.Lock()
+	secrets := append([]string{}, *logger.secrets...)
+	logger.mu.Unlock()
+
+	sort.Slice(secrets, func(i, j int) bool { return len(secrets[i]) > len(secrets[j]) })
+
+	return func(text string) string {
+		for _, secret := range secrets {
+			text = strings.ReplaceAll(text, secret, redacted)
+		}
+
+		for _, pattern := range secretPatterns {
+			if pattern.NumSubexp() == 0 {
+				text = pattern.ReplaceAllString(text, redacted)
+				continue
+			}
+			text = pattern.ReplaceAllString(text, "${1}"+redacted+"${2}")
+		}
+		return text
+	}
+}
+
+func key(keyvals []interface{}, i int) string {
+	if i == len(keyvals)-1 {
+		return "extra"
+	}
+	return fmt.Sprint(keyvals[i])
+}
+
+func value(keyvals []interface{}, i int) string {
+	if i == len(keyvals)-1 {
+		return fmt.Sprint(keyvals[i])
+	}
+	return fmt.Sprint(keyvals[i+1])
+}
+
+// quote quotes the values containing spaces, quotes or equal signs.
+func quote(value string) string {
+	if value == "" || strings.ContainsAny(value, " \t\n\"=") {
+		return strconv.Quote(value)
+	}
+	return value
+}
+
+// Client returns a copy of the client logging its requests at debug level with their status and duration.
+// SuppressedHeader marks the responses made up for the requests which were not sent, such as the mutations of a
+// dry run, its value telling why.
+const SuppressedHeader = "X-Suppressed"
+
+func (logger *Logger) Client(client *http.Client) *http.Client {
+	logged := *client
+	logged.Transport = &transport{next: client.Transport, logger: logger}
+	return &logged
+}
+
+type transport struct {
+	next   http.RoundTripper
+	logger *Logger
+}
+
+func (t *transport) RoundTrip(request *http.Request) (*http.Response, error) {
+	next := t.next
+	if next == nil {
+		next = http.DefaultTransport
+	}
+
+	start := time.Now()
+	response, err := next.RoundTrip(request)
+	duration := time.Since(start).Round(time.Millisecond)
+
+	if err != nil {
+		t.logger.Debug("api call failed", "method", request.Method, "url", request.URL.String(), "duration", duration, "error", err)
+		return nil, err
+	}
+
+	if reason := response.Header.Get(SuppressedHeader); reason != "" {
+		t.logger.Debug("api call suppressed", "method", request.Method, "url", request.URL.String(), "reason", reason)
+		return response, nil
+	}
+
+	t.logger.Debug("api call", "method", request.Method, "url", request.URL.String(), "status", response.StatusCode, "duration", duration)
+	return response, nil
+}
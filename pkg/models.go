@@ -15,20 +15,71 @@ type Source struct {
 	PrivateToken       string   `json:"private_token"`
 	Insecure           bool     `json:"insecure"`
 	Recursive          bool     `json:"recursive,omitempty"`
+	SubmoduleDepth     int      `json:"submodule_depth,omitempty"`
+	SubmodulePaths     []string `json:"submodule_paths,omitempty"`
 	SkipWorkInProgress bool     `json:"skip_work_in_progress,omitempty"`
 	SkipNotMergeable   bool     `json:"skip_not_mergeable,omitempty"`
 	SkipTriggerComment bool     `json:"skip_trigger_comment,omitempty"`
 	ConcourseUrl       string   `json:"concourse_url,omitempty"`
 	PipelineName       string   `json:"pipeline_name,omitempty"`
+	Context            string   `json:"context,omitempty"`
+	SkipPendingStatus  bool     `json:"skip_pending_status,omitempty"`
 	Labels             []string `json:"labels,omitempty"`
 	TargetBranch       string   `json:"target_branch,omitempty"`
 	SourceBranch       string   `json:"source_branch,omitempty"`
 	Sort               string   `json:"sort,omitempty"`
 	Paths              []string `json:"paths,omitempty"`
 	IgnorePaths        []string `json:"ignore_paths,omitempty"`
-	SshKeys            []string `json:"ssh_keys,omitempty"`
+	SshKeys            []SshKey `json:"ssh_keys,omitempty"`
+	SshKnownHosts      []string `json:"ssh_known_hosts,omitempty"`
+	SshHostKeyCheck    string   `json:"ssh_host_key_check,omitempty"`
+	GitBackend         string   `json:"git_backend,omitempty"`
+	GitUserName        string   `json:"git_user_name,omitempty"`
+	GitUserEmail       string   `json:"git_user_email,omitempty"`
+	StatusProject      string   `json:"status_project,omitempty"`
+	DryRun             bool     `json:"dry_run,omitempty"`
+	LogLevel           string   `json:"log_level,omitempty"`
+	LogFormat          string   `json:"log_format,omitempty"`
 }
 
+// SshKey is a private key added to the ssh-agent, given either as a plain string or as an object with a passphrase.
+type SshKey struct {
+	Key        string `json:"key"`
+	Passphrase string `json:"passphrase,omitempty"`
+}
+
+func (key *SshKey) UnmarshalJSON(data []byte) error {
+	var raw string
+	if err := json.Unmarshal(data, &raw); err == nil {
+		key.Key = raw
+		return nil
+	}
+
+	type plain SshKey
+	return json.Unmarshal(data, (*plain)(key))
+}
+
+// Host key verification modes of the ssh connections made for submodules.
+const (
+	HostKeyCheckStrict    = "strict"
+	HostKeyCheckAcceptNew = "accept-new"
+	HostKeyCheckOff       = "off"
+)
+
+// Git backends used by in to clone the repository, either the git binary or the pure go implementation.
+const (
+	GitBackendGit   = "git"
+	GitBackendGoGit = "go-git"
+)
+
+// Projects the commit statuses are set on. By default they are set on the target project, where the merge
+// request lives, falling back to the source project of a fork.
+const (
+	StatusProjectAuto   = "auto"
+	StatusProjectTarget = "target"
+	StatusProjectSource = "source"
+)
+
 type Version struct {
 	ID        int        `json:"id,string"`
 	UpdatedAt *time.Time `json:"updated_at"`
@@ -94,6 +145,15 @@ func (source *Source) GetPipelineName() string {
 
 }
 
+// GetStatusName returns the default name of the commit statuses, shared by the pending status of check and the
+// statuses of out: the context, with the Concourse build variables expanded, or the pipeline name.
+func (source *Source) GetStatusName() string {
+	if source.Context != "" {
+		return ExpandBuildVariables(source.Context)
+	}
+	return source.GetPipelineName()
+}
+
 func (source *Source) GetSort() (string, error) {
 	order := strings.ToLower(source.Sort)
 	switch order {
@@ -120,3 +180,41 @@ func (source *Source) AcceptPath(path string) bool {
 
 	return !excluded && included
 }
+
+func (source *Source) GetGitBackend() (string, error) {
+	backend := strings.ToLower(source.GitBackend)
+	switch backend {
+	case "":
+		return GitBackendGit, nil
+	case GitBackendGit, GitBackendGoGit:
+		return backend, nil
+	}
+	return "", fmt.Errorf("invalid value for git_backend: %v", source.GitBackend)
+}
+
+func (source *Source) GetStatusProject() (string, error) {
+	project := strings.ToLower(source.StatusProject)
+	switch project {
+	case "":
+		return StatusProjectAuto, nil
+	case StatusProjectAuto, StatusProjectTarget, StatusProjectSource:
+		return project, nil
+	}
+	return "", fmt.Errorf("invalid value for status_project: %v", source.StatusProject)
+}
+
+// GetSshHostKeyCheck returns the host key verification mode, host keys are strictly checked by default
+// when known hosts are provided.
+func (source *Source) GetSshHostKeyCheck() (string, error) {
+	check := strings.ToLower(source.SshHostKeyCheck)
+	switch check {
+	case "":
+		if len(source.SshKnownHosts) > 0 {
+			return HostKeyCheckStrict, nil
+		}
+		return HostKeyCheckOff, nil
+	case HostKeyCheckStrict, HostKeyCheckAcceptNew, HostKeyCheckOff:
+		return check, nil
+	}
+	return "", fmt.Errorf("invalid value for ssh_host_key_check: %v", source.SshHostKeyCheck)
+}
@@ -1,7 +1,9 @@
 package pkg
 
 import (
+	"encoding/json"
 	"os"
+	"reflect"
 	"testing"
 )
 
@@ -233,3 +235,89 @@ func TestSource_GetTargetURL(t *testing.T) {
 	}
 
 }
+
+func TestSource_GetStatusName(t *testing.T) {
+	os.Setenv("BUILD_PIPELINE_NAME", "beta")
+
+	tests := []struct {
+		source Source
+		want   string
+	}{
+		{Source{}, "beta"},
+		{Source{PipelineName: "alpha"}, "alpha"},
+		{Source{PipelineName: "alpha", Context: "$BUILD_PIPELINE_NAME/unit"}, "beta/unit"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			if got := tt.source.GetStatusName(); got != tt.want {
+				t.Errorf("GetStatusName() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSource_GetGitBackend(t *testing.T) {
+	tests := []struct {
+		backend string
+		want    string
+		wantErr bool
+	}{
+		{"", "git", false},
+		{"git", "git", false},
+		{"go-git", "go-git", false},
+		{"Go-Git", "go-git", false},
+		{"libgit2", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.backend, func(t *testing.T) {
+			source := Source{GitBackend: tt.backend}
+			got, err := source.GetGitBackend()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("GetGitBackend() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("GetGitBackend() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSource_GetSshHostKeyCheck(t *testing.T) {
+	tests := []struct {
+		check      string
+		knownHosts []string
+		want       string
+		wantErr    bool
+	}{
+		{"", nil, "off", false},
+		{"", []string{"gitlab.example.com ssh-ed25519 AAAA"}, "strict", false},
+		{"accept-new", nil, "accept-new", false},
+		{"OFF", []string{"gitlab.example.com ssh-ed25519 AAAA"}, "off", false},
+		{"maybe", nil, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.check, func(t *testing.T) {
+			source := Source{SshHostKeyCheck: tt.check, SshKnownHosts: tt.knownHosts}
+			got, err := source.GetSshHostKeyCheck()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("GetSshHostKeyCheck() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("GetSshHostKeyCheck() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSshKey_UnmarshalJSON(t *testing.T) {
+	var source Source
+	err := json.Unmarshal([]byte(`{"ssh_keys":["plain",{"key":"encrypted","passphrase":"secret"}]}`), &source)
+	if err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+
+	want := []SshKey{{Key: "plain"}, {Key: "encrypted", Passphrase: "secret"}}
+	if !reflect.DeepEqual(source.SshKeys, want) {
+		t.Errorf("Unmarshal() got = %v, want %v", source.SshKeys, want)
+	}
+}
@@ -0,0 +1,67 @@
+package out
+
+import (
+	"fmt"
+	"github.com/xanzy/go-gitlab"
+)
+
+// updateApproval approves or revokes the approval of the merge request by the user of the token. Nothing is done
+// when the user has already approved, or not approved, the merge request.
+func (command *Command) updateApproval(request Request, mr gitlab.MergeRequest) error {
+	if request.Params.Approve == nil {
+		return nil
+	}
+
+	if request.Params.ApproveVerifySHA {
+		err := command.verifySHA(mr)
+		if err != nil {
+			return err
+		}
+	}
+
+	approvals, _, err := command.client.MergeRequestApprovals.GetConfiguration(mr.ProjectID, mr.IID)
+	if err != nil {
+		return err
+	}
+
+	if *request.Params.Approve == approvals.UserHasApproved {
+		command.logger.Debug("approval unchanged", "iid", mr.IID, "approved", approvals.UserHasApproved)
+		return nil
+	}
+
+	if !*request.Params.Approve {
+		_, err = command.client.MergeRequestApprovals.UnapproveMergeRequest(mr.ProjectID, mr.IID)
+		if err != nil {
+			return err
+		}
+		command.logger.Info("revoked approval", "iid", mr.IID)
+		return nil
+	}
+
+	options := gitlab.ApproveMergeRequestOptions{}
+	if request.Params.ApproveVerifySHA {
+		// gitlab refuses the approval as well if the merge request was updated in the meantime
+		options.SHA = &mr.SHA
+	}
+
+	_, _, err = command.client.MergeRequestApprovals.ApproveMergeRequest(mr.ProjectID, mr.IID, &options)
+	if err != nil {
+		return err
+	}
+	command.logger.Info("approved merge request", "iid", mr.IID, "sha", mr.SHA)
+	return nil
+}
+
+// verifySHA fails when the head of the merge request is no longer the commit which was fetched by the get step.
+func (command *Command) verifySHA(mr gitlab.MergeRequest) error {
+	current, _, err := command.client.MergeRequests.GetMergeRequest(mr.ProjectID, mr.IID, &gitlab.GetMergeRequestsOptions{})
+	if err != nil {
+		return err
+	}
+
+	if current.SHA != mr.SHA {
+		return fmt.Errorf("merge request head %s does not match the tested sha %s", current.SHA, mr.SHA)
+	}
+
+	return nil
+}
@@ -0,0 +1,47 @@
+package out
+
+import (
+	"fmt"
+	"github.com/xanzy/go-gitlab"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// uploadAttachments uploads the files relative to folder to the project of the merge request and returns their
+// markdown links by file. In dry run, the links point to the local files.
+func (command *Command) uploadAttachments(request Request, mr gitlab.MergeRequest, folder string, files []string) (map[string]string, error) {
+	links := make(map[string]string)
+	for _, file := range files {
+		content, err := os.Open(filepath.Join(folder, file))
+		if err != nil {
+			return nil, err
+		}
+
+		upload, _, err := command.client.Projects.UploadFile(mr.ProjectID, content, path.Base(file))
+		content.Close()
+		if err != nil {
+			return nil, fmt.Errorf("uploading attachment %s: %w", file, err)
+		}
+
+		command.logger.Info("uploaded attachment", "file", file)
+		links[file] = upload.Markdown
+		if request.DryRun() {
+			links[file] = localLink(file)
+		}
+	}
+	return links, nil
+}
+
+// localLinks returns the markdown links to the local files, by file.
+func localLinks(files []string) map[string]string {
+	links := make(map[string]string)
+	for _, file := range files {
+		links[file] = localLink(file)
+	}
+	return links
+}
+
+func localLink(file string) string {
+	return fmt.Sprintf("[%s](%s)", path.Base(file), file)
+}
@@ -1,57 +1,190 @@
 package out
 
 import (
-	"encoding/json"
 	"github.com/samcontesse/gitlab-merge-request-resource/pkg"
+	"github.com/samcontesse/gitlab-merge-request-resource/pkg/junit"
+	"github.com/samcontesse/gitlab-merge-request-resource/pkg/logging"
 	"github.com/xanzy/go-gitlab"
+	"io"
+	"net/http"
 	"os"
-	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type Command struct {
-	client *gitlab.Client
+	client       *gitlab.Client
+	pollInterval time.Duration
+	logger       *logging.Logger
+	dryRunOutput io.Writer
 }
 
 func NewCommand(client *gitlab.Client) *Command {
-	return &Command{client}
+	return &Command{client, 2 * time.Second, logging.Default(), os.Stderr}
 }
 
+// WithPollInterval sets the interval between two checks of a long running operation such as a rebase.
+func (command *Command) WithPollInterval(interval time.Duration) *Command {
+	command.pollInterval = interval
+	return command
+}
+
+func (command *Command) WithLogger(logger *logging.Logger) *Command {
+	command.logger = logger
+	return command
+}
+
+// WithDryRunOutput sets where the mutations are logged in dry run, stderr by default.
+func (command *Command) WithDryRunOutput(output io.Writer) *Command {
+	command.dryRunOutput = output
+	return command
+}
+
+// Run applies the params to the merge request. In dry run, the mutations are logged instead of being sent,
+// whatever the client of the command.
 func (command *Command) Run(destination string, request Request) (Response, error) {
-	repo := filepath.Join(destination, request.Params.Repository)
-	err := os.MkdirAll(repo, 0755)
+	if request.DryRun() {
+		client, err := command.dryRunClient(request)
+		if err != nil {
+			return Response{}, err
+		}
+		dryRun := *command
+		dryRun.client = client
+		return dryRun.run(destination, request)
+	}
+	return command.run(destination, request)
+}
+
+// dryRunClient returns a client to the api of the command client, sending the read requests through the default
+// transport and logging the mutations on the dry run output.
+func (command *Command) dryRunClient(request Request) (*gitlab.Client, error) {
+	transport := &pkg.DryRunTransport{Transport: http.DefaultTransport, Output: command.dryRunOutput}
+	httpClient := command.logger.Client(&http.Client{Transport: transport})
+	return gitlab.NewClient(request.Source.PrivateToken, gitlab.WithHTTPClient(httpClient), gitlab.WithBaseURL(command.client.BaseURL().String()))
+}
+
+func (command *Command) run(destination string, request Request) (Response, error) {
+	err := request.Params.Comment.Validate()
+	if err != nil {
+		return Response{}, err
+	}
+
+	err = request.Params.Update.Validate()
+	if err != nil {
+		return Response{}, err
+	}
+
+	err = request.Params.ValidateMergeRequest()
+	if err != nil {
+		return Response{}, err
+	}
+
+	err = request.Params.ValidateMerge()
+	if err != nil {
+		return Response{}, err
+	}
+
+	_, err = request.Source.GetStatusProject()
+	if err != nil {
+		return Response{}, err
+	}
+
+	mr, err := command.getMergeRequest(destination, request)
+	if err != nil {
+		return Response{}, err
+	}
+	command.logger.Info("resolved merge request", "project", mr.ProjectID, "iid", mr.IID, "sha", mr.SHA)
+
+	coverage, err := request.Params.Coverage.Read(destination)
+	if err != nil {
+		return Response{}, err
+	}
+
+	review, err := request.Params.Review.Read(destination)
+	if err != nil {
+		return Response{}, err
+	}
+
+	tests, err := readTestReports(destination, request.Params.TestReports)
+	if err != nil {
+		return Response{}, err
+	}
+
+	attachments, err := request.Params.Attachments.Resolve(destination)
+	if err != nil {
+		return Response{}, err
+	}
+
+	// the comment is rendered first, the attachments linking to the local files, so that an invalid template fails
+	// before anything is uploaded or posted on the merge request
+	body, err := command.renderComment(destination, request, mr, coverage, localLinks(attachments))
+	if err != nil {
+		return Response{}, err
+	}
+
+	if len(attachments) > 0 {
+		links, err := command.uploadAttachments(request, mr, destination, attachments)
+		if err != nil {
+			return Response{}, err
+		}
+
+		body, err = command.renderComment(destination, request, mr, coverage, links)
+		if err != nil {
+			return Response{}, err
+		}
+	}
+
+	if tests != nil {
+		body = strings.TrimSpace(body + "\n\n" + tests.Markdown(failedTestsLimit))
+	}
+
+	merge, err := mergeOptions(request, mr)
+	if err != nil {
+		return Response{}, err
+	}
+
+	update, err := command.updateOptions(request, mr)
 	if err != nil {
 		return Response{}, err
 	}
 
-	err = os.Chdir(repo)
+	err = command.updateCommitStatus(request, mr, coverage, tests)
 	if err != nil {
 		return Response{}, err
 	}
 
-	file, err := os.ReadFile(".git/merge-request.json")
+	mr, err = command.updateLabels(request, mr)
 	if err != nil {
 		return Response{}, err
 	}
 
-	var mr gitlab.MergeRequest
-	err = json.Unmarshal(file, &mr)
+	mr, err = command.updateMergeRequest(request, mr, update)
 	if err != nil {
 		return Response{}, err
 	}
 
-	err = command.updateCommitStatus(request, mr)
+	err = command.updateApproval(request, mr)
 	if err != nil {
 		return Response{}, err
 	}
 
-	err = command.updateLabels(request, mr)
+	err = command.createNote(request, mr, body)
 	if err != nil {
 		return Response{}, err
 	}
 
-	err = command.createNote(destination, request, mr)
+	err = command.postReview(mr, review)
+	if err != nil {
+		return Response{}, err
+	}
+
+	mr, err = command.rebaseMergeRequest(request, mr)
+	if err != nil {
+		return Response{}, err
+	}
+
+	err = command.acceptMergeRequest(request, mr, merge)
 	if err != nil {
 		return Response{}, err
 	}
@@ -67,66 +200,135 @@ func (command *Command) Run(destination string, request Request) (Response, erro
 	return response, nil
 }
 
-func (command *Command) createNote(destination string, request Request, mr gitlab.MergeRequest) error {
-	body, err := request.Params.Comment.ReadContent(destination)
+func (command *Command) renderComment(destination string, request Request, mr gitlab.MergeRequest, coverage *float64, attachments map[string]string) (string, error) {
+	variables := templateVariables(request, mr)
+	variables["Attachments"] = attachments
+
+	if coverage != nil {
+		variables["Coverage"] = strconv.FormatFloat(*coverage, 'f', 2, 64)
+	}
+
+	body, err := request.Params.Comment.Render(destination, variables)
 	if err != nil {
-		return err
+		return "", err
+	}
+
+	if coverage != nil {
+		body = strings.Replace(body, "$COVERAGE", variables["Coverage"].(string), -1)
+	}
+
+	return body, nil
+}
+
+func (command *Command) createNote(request Request, mr gitlab.MergeRequest, body string) error {
+	if request.Params.Comment.Mode == CommentModeSticky {
+		return command.updateStickyNote(request, mr, body)
 	}
 
 	if body != "" {
 		options := gitlab.CreateMergeRequestNoteOptions{Body: &body}
-		_, _, err := command.client.Notes.CreateMergeRequestNote(mr.SourceProjectID, mr.IID, &options)
+		_, _, err := command.client.Notes.CreateMergeRequestNote(mr.ProjectID, mr.IID, &options)
 		if err != nil {
 			return err
 		}
+		command.logger.Info("created note", "iid", mr.IID)
 	}
 	return nil
 }
 
-func (command *Command) updateLabels(request Request, mr gitlab.MergeRequest) error {
-	if request.Params.Labels != nil {
+// templateVariables returns the merge request fields and the Concourse build metadata available to comment
+// templates. Build variables missing from the environment are left out so that templates using them fail.
+func templateVariables(request Request, mr gitlab.MergeRequest) map[string]interface{} {
+	author, username := "", ""
+	if mr.Author != nil {
+		author, username = mr.Author.Name, mr.Author.Username
+	}
 
-		// exclude empty string when there is no tags
-		// this should be fixed in go-gitlab
-		if len(mr.Labels) == 1 && mr.Labels[0] == "" {
-			mr.Labels = mr.Labels[1:]
+	build := make(map[string]interface{})
+	for name, env := range map[string]string{
+		"Team":     "BUILD_TEAM_NAME",
+		"Pipeline": "BUILD_PIPELINE_NAME",
+		"Job":      "BUILD_JOB_NAME",
+		"Name":     "BUILD_NAME",
+		"ID":       "BUILD_ID",
+	} {
+		if value := os.Getenv(env); value != "" {
+			build[name] = value
 		}
+	}
+	if request.Source.GetCoucourseUrl() != "" {
+		build["URL"] = request.Source.GetTargetURL()
+	}
+
+	return map[string]interface{}{
+		"MergeRequest": map[string]interface{}{
+			"ID":             mr.ID,
+			"IID":            mr.IID,
+			"SHA":            mr.SHA,
+			"Title":          mr.Title,
+			"Description":    mr.Description,
+			"Author":         author,
+			"AuthorUsername": username,
+			"SourceBranch":   mr.SourceBranch,
+			"TargetBranch":   mr.TargetBranch,
+			"WebURL":         mr.WebURL,
+			"Labels":         []string(mr.Labels),
+		},
+		"Build": build,
+	}
+}
 
-		labels := append(mr.Labels, request.Params.Labels...)
-		options := gitlab.UpdateMergeRequestOptions{Labels: &labels}
+func (command *Command) updateLabels(request Request, mr gitlab.MergeRequest) (gitlab.MergeRequest, error) {
+	params := request.Params
+	if params.Labels == nil && params.AddLabels == nil && params.RemoveLabels == nil && params.ReplaceLabels == nil {
+		return mr, nil
+	}
 
-		result, _, err := command.client.MergeRequests.UpdateMergeRequest(mr.SourceProjectID, mr.IID, &options)
-		if err != nil {
-			return err
-		}
+	labels := gitlab.Labels(applyLabels(mr.Labels, params))
+	options := gitlab.UpdateMergeRequestOptions{Labels: &labels}
 
-		mr = *result
+	_, _, err := command.client.MergeRequests.UpdateMergeRequest(mr.ProjectID, mr.IID, &options)
+	if err != nil {
+		return mr, err
 	}
-	return nil
+
+	command.logger.Info("updated labels", "iid", mr.IID, "labels", strings.Join(labels, ","))
+	mr.Labels = labels
+	return mr, nil
 }
 
-func (command *Command) updateCommitStatus(request Request, mr gitlab.MergeRequest) error {
+func (command *Command) updateCommitStatus(request Request, mr gitlab.MergeRequest, coverage *float64, tests *junit.Report) error {
 	if request.Params.Status != "" {
 		state := gitlab.BuildState(gitlab.BuildStateValue(request.Params.Status))
 		target := request.Source.GetTargetURL()
-		name := request.Source.GetPipelineName()
+		name := request.Params.GetStatusName(request.Source)
 		options := gitlab.SetCommitStatusOptions{
 			Name:      &name,
 			TargetURL: &target,
 			State:     *state,
+			Coverage:  coverage,
 		}
 
-		_, _, err := command.client.Commits.SetCommitStatus(mr.SourceProjectID, mr.SHA, &options)
+		if request.Params.Description != "" {
+			description := pkg.ExpandBuildVariables(request.Params.Description)
+			options.Description = &description
+		} else if request.Params.TestReportsDescription && tests != nil {
+			description := tests.Description()
+			options.Description = &description
+		}
+
+		err := pkg.SetCommitStatus(command.client, request.Source, &mr, &options)
 		if err != nil {
 			return err
 		}
+		command.logger.Info("set commit status", "sha", mr.SHA, "name", name, "state", request.Params.Status)
 	}
 	return nil
 }
 
 func buildMetadata(mr *gitlab.MergeRequest) pkg.Metadata {
 
-	return []pkg.MetadataField{
+	metadata := []pkg.MetadataField{
 		{
 			Name:  "id",
 			Value: strconv.Itoa(mr.ID),
@@ -164,4 +366,28 @@ func buildMetadata(mr *gitlab.MergeRequest) pkg.Metadata {
 			Value: strings.Join(mr.Labels, ","),
 		},
 	}
+
+	if mr.State != "" {
+		metadata = append(metadata, pkg.MetadataField{Name: "state", Value: mr.State})
+	}
+	metadata = append(metadata, pkg.MetadataField{Name: "draft", Value: strconv.FormatBool(mr.Draft || mr.WorkInProgress)})
+	if len(mr.Assignees) > 0 {
+		metadata = append(metadata, pkg.MetadataField{Name: "assignees", Value: usernames(mr.Assignees)})
+	}
+	if len(mr.Reviewers) > 0 {
+		metadata = append(metadata, pkg.MetadataField{Name: "reviewers", Value: usernames(mr.Reviewers)})
+	}
+	if mr.Milestone != nil {
+		metadata = append(metadata, pkg.MetadataField{Name: "milestone", Value: mr.Milestone.Title})
+	}
+
+	return metadata
+}
+
+func usernames(users []*gitlab.BasicUser) string {
+	names := make([]string, 0, len(users))
+	for _, user := range users {
+		names = append(names, user.Username)
+	}
+	return strings.Join(names, ",")
 }
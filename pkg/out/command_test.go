@@ -1,6 +1,7 @@
 package out_test
 
 import (
+	"bytes"
 	"encoding/json"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
@@ -13,6 +14,7 @@ import (
 	"net/url"
 	"os"
 	"path"
+	"time"
 )
 
 var _ = Describe("Out", func() {
@@ -40,6 +42,11 @@ var _ = Describe("Out", func() {
 		_ = os.Setenv("BUILD_JOB_NAME", "release")
 		_ = os.Setenv("BUILD_NAME", "1")
 
+		mux.HandleFunc("/api/v4/user", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("content-type", "application/json")
+			w.Write([]byte(`{"id": 5, "username": "ci"}`))
+		})
+
 		command = out.NewCommand(client)
 	})
 
@@ -51,7 +58,7 @@ var _ = Describe("Out", func() {
 	Describe("Only update status", func() {
 
 		BeforeEach(func() {
-			mr := gitlab.MergeRequest{ID: 1, IID: 42, SHA: "abc", SourceProjectID: 1, Author: &gitlab.BasicUser{Name: "john"}}
+			mr := gitlab.MergeRequest{ID: 1, IID: 42, SHA: "abc", ProjectID: 1, SourceProjectID: 1, Author: &gitlab.BasicUser{Name: "john"}}
 			content, _ := json.Marshal(mr)
 
 			_ = os.Mkdir(path.Join(destination, "repo"), 0755)
@@ -88,18 +95,135 @@ var _ = Describe("Out", func() {
 
 	})
 
+	Describe("Update named status", func() {
+
+		BeforeEach(func() {
+			mr := gitlab.MergeRequest{ID: 1, IID: 42, SHA: "abc", ProjectID: 1, SourceProjectID: 1, Author: &gitlab.BasicUser{Name: "john"}}
+			content, _ := json.Marshal(mr)
+
+			_ = os.Mkdir(path.Join(destination, "repo"), 0755)
+			_ = os.Mkdir(path.Join(destination, "repo", ".git"), 0755)
+			_ = os.WriteFile(path.Join(destination, "repo", ".git", "merge-request.json"), content, 0644)
+		})
+
+		It("Sets the commit status with its context and description", func() {
+			project, _ := url.Parse("namespace/project.git")
+			uri := root.ResolveReference(project)
+
+			request := out.Request{
+				Source: pkg.Source{URI: uri.String()},
+				Params: out.Params{
+					Repository:  "repo",
+					Status:      "success",
+					Context:     "$BUILD_PIPELINE_NAME/lint",
+					Description: "build ${BUILD_NAME}",
+				},
+			}
+
+			mux.HandleFunc("/api/v4/projects/1/statuses/abc", func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				Expect(string(body)).To(ContainSubstring(`"name":"baltic/lint"`))
+				Expect(string(body)).To(ContainSubstring(`"description":"build 1"`))
+				status := gitlab.CommitStatus{ID: 1, SHA: "abc"}
+				output, _ := json.Marshal(status)
+				w.Header().Set("content-type", "application/json")
+				w.WriteHeader(http.StatusCreated)
+				w.Write(output)
+			})
+
+			_, err := command.Run(destination, request)
+			Expect(err).Should(BeNil())
+		})
+
+		It("Sets the commit status under the source context by default", func() {
+			project, _ := url.Parse("namespace/project.git")
+			uri := root.ResolveReference(project)
+
+			request := out.Request{
+				Source: pkg.Source{URI: uri.String(), Context: "$BUILD_PIPELINE_NAME/unit"},
+				Params: out.Params{
+					Repository: "repo",
+					Status:     "success",
+				},
+			}
+
+			mux.HandleFunc("/api/v4/projects/1/statuses/abc", func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				Expect(string(body)).To(ContainSubstring(`"name":"baltic/unit"`))
+				status := gitlab.CommitStatus{ID: 1, SHA: "abc"}
+				output, _ := json.Marshal(status)
+				w.Header().Set("content-type", "application/json")
+				w.WriteHeader(http.StatusCreated)
+				w.Write(output)
+			})
+
+			_, err := command.Run(destination, request)
+			Expect(err).Should(BeNil())
+		})
+
+	})
+
+	Describe("Report coverage", func() {
+
+		BeforeEach(func() {
+			mr := gitlab.MergeRequest{ID: 1, IID: 42, SHA: "abc", ProjectID: 1, SourceProjectID: 1, Author: &gitlab.BasicUser{Name: "john"}}
+			content, _ := json.Marshal(mr)
+
+			_ = os.Mkdir(path.Join(destination, "repo"), 0755)
+			_ = os.Mkdir(path.Join(destination, "repo", ".git"), 0755)
+			_ = os.WriteFile(path.Join(destination, "repo", ".git", "merge-request.json"), content, 0644)
+			_ = os.WriteFile(path.Join(destination, "cover.out"), []byte("mode: set\na.go:1.1,2.2 3 1\na.go:4.1,5.2 1 0\n"), 0644)
+		})
+
+		It("Sets the coverage parsed from the report on the status and the comment", func() {
+			project, _ := url.Parse("namespace/project.git")
+			uri := root.ResolveReference(project)
+
+			var params out.Params
+			_ = json.Unmarshal([]byte(`{"repository":"repo","status":"success","coverage":"cover.out","comment":{"text":"coverage: $COVERAGE%"}}`), &params)
+			request := out.Request{
+				Source: pkg.Source{URI: uri.String()},
+				Params: params,
+			}
+
+			mux.HandleFunc("/api/v4/projects/1/statuses/abc", func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				Expect(string(body)).To(ContainSubstring(`"coverage":75`))
+				status := gitlab.CommitStatus{ID: 1, SHA: "abc"}
+				output, _ := json.Marshal(status)
+				w.Header().Set("content-type", "application/json")
+				w.WriteHeader(http.StatusCreated)
+				w.Write(output)
+			})
+
+			mux.HandleFunc("/api/v4/projects/1/merge_requests/42/notes", func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				Expect(string(body)).To(ContainSubstring(`coverage: 75.00%`))
+				n := gitlab.Note{ID: 1}
+				output, _ := json.Marshal(n)
+				w.Header().Set("content-type", "application/json")
+				w.WriteHeader(http.StatusCreated)
+				w.Write(output)
+			})
+
+			_, err := command.Run(destination, request)
+			Expect(err).Should(BeNil())
+		})
+
+	})
+
 	Describe("Only update labels", func() {
 
 		BeforeEach(func() {
 			mux.HandleFunc("/api/v4/projects/1/merge_requests/42", func(w http.ResponseWriter, r *http.Request) {
-				mr := gitlab.MergeRequest{ID: 1, IID: 42, SHA: "abc", SourceProjectID: 1, Author: &gitlab.BasicUser{Name: "john"}}
+				mr := gitlab.MergeRequest{ID: 1, IID: 42, SHA: "abc", ProjectID: 1, SourceProjectID: 1, Author: &gitlab.BasicUser{Name: "john"}}
 				output, _ := json.Marshal(mr)
 				w.Header().Set("content-type", "application/json")
 				w.WriteHeader(http.StatusOK)
 				w.Write(output)
 			})
 
-			mr := gitlab.MergeRequest{ID: 1, IID: 42, SourceProjectID: 1, Labels: []string{"existing-label"}, Author: &gitlab.BasicUser{Name: "john"}}
+			mr := gitlab.MergeRequest{ID: 1, IID: 42, ProjectID: 1, SourceProjectID: 1, Labels: []string{"existing-label"}, Author: &gitlab.BasicUser{Name: "john"}}
 			content, _ := json.Marshal(mr)
 
 			_ = os.Mkdir(path.Join(destination, "repo"), 0755)
@@ -130,14 +254,14 @@ var _ = Describe("Out", func() {
 
 		BeforeEach(func() {
 			mux.HandleFunc("/api/v4/projects/1/merge_requests/42", func(w http.ResponseWriter, r *http.Request) {
-				mr := gitlab.MergeRequest{ID: 1, IID: 42, SHA: "abc", SourceProjectID: 1, Author: &gitlab.BasicUser{Name: "john"}}
+				mr := gitlab.MergeRequest{ID: 1, IID: 42, SHA: "abc", ProjectID: 1, SourceProjectID: 1, Author: &gitlab.BasicUser{Name: "john"}}
 				output, _ := json.Marshal(mr)
 				w.Header().Set("content-type", "application/json")
 				w.WriteHeader(http.StatusOK)
 				w.Write(output)
 			})
 
-			mr := gitlab.MergeRequest{ID: 1, IID: 42, SourceProjectID: 1, SHA: "abc", Labels: []string{"existing-label"}, Author: &gitlab.BasicUser{Name: "john"}}
+			mr := gitlab.MergeRequest{ID: 1, IID: 42, ProjectID: 1, SourceProjectID: 1, SHA: "abc", Labels: []string{"existing-label"}, Author: &gitlab.BasicUser{Name: "john"}}
 			content, _ := json.Marshal(mr)
 
 			_ = os.Mkdir(path.Join(destination, "repo"), 0755)
@@ -179,14 +303,14 @@ var _ = Describe("Out", func() {
 
 		BeforeEach(func() {
 			mux.HandleFunc("/api/v4/projects/1/merge_requests/42", func(w http.ResponseWriter, r *http.Request) {
-				mr := gitlab.MergeRequest{ID: 1, IID: 42, SHA: "abc", SourceProjectID: 1, Author: &gitlab.BasicUser{Name: "john"}}
+				mr := gitlab.MergeRequest{ID: 1, IID: 42, SHA: "abc", ProjectID: 1, SourceProjectID: 1, Author: &gitlab.BasicUser{Name: "john"}}
 				output, _ := json.Marshal(mr)
 				w.Header().Set("content-type", "application/json")
 				w.WriteHeader(http.StatusOK)
 				w.Write(output)
 			})
 
-			mr := gitlab.MergeRequest{ID: 1, IID: 42, SourceProjectID: 1, SHA: "abc", Labels: []string{"existing-label"}, Author: &gitlab.BasicUser{Name: "john"}}
+			mr := gitlab.MergeRequest{ID: 1, IID: 42, ProjectID: 1, SourceProjectID: 1, SHA: "abc", Labels: []string{"existing-label"}, Author: &gitlab.BasicUser{Name: "john"}}
 			content, _ := json.Marshal(mr)
 
 			_ = os.Mkdir(path.Join(destination, "repo"), 0755)
@@ -223,4 +347,1261 @@ var _ = Describe("Out", func() {
 
 	})
 
-})
+	Describe("Sticky comment", func() {
+
+		var (
+			notes   []gitlab.Note
+			created []string
+			updated map[int]string
+			deleted []int
+			request out.Request
+		)
+
+		BeforeEach(func() {
+			notes = nil
+			created = nil
+			updated = make(map[int]string)
+			deleted = nil
+
+			mr := gitlab.MergeRequest{ID: 1, IID: 42, SHA: "abc", ProjectID: 1, SourceProjectID: 1, Author: &gitlab.BasicUser{Name: "john"}}
+			content, _ := json.Marshal(mr)
+
+			_ = os.Mkdir(path.Join(destination, "repo"), 0755)
+			_ = os.Mkdir(path.Join(destination, "repo", ".git"), 0755)
+			_ = os.WriteFile(path.Join(destination, "repo", ".git", "merge-request.json"), content, 0644)
+
+			mux.HandleFunc("/api/v4/projects/1/merge_requests/42/notes", func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("content-type", "application/json")
+				if r.Method == http.MethodPost {
+					var options gitlab.CreateMergeRequestNoteOptions
+					_ = json.NewDecoder(r.Body).Decode(&options)
+					created = append(created, *options.Body)
+					w.WriteHeader(http.StatusCreated)
+					w.Write([]byte(`{"id":9}`))
+					return
+				}
+				output, _ := json.Marshal(notes)
+				w.WriteHeader(http.StatusOK)
+				w.Write(output)
+			})
+			mux.HandleFunc("/api/v4/projects/1/merge_requests/42/notes/7", func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("content-type", "application/json")
+				switch r.Method {
+				case http.MethodPut:
+					var options gitlab.UpdateMergeRequestNoteOptions
+					_ = json.NewDecoder(r.Body).Decode(&options)
+					updated[7] = *options.Body
+					w.WriteHeader(http.StatusOK)
+					w.Write([]byte(`{"id":7}`))
+				case http.MethodDelete:
+					deleted = append(deleted, 7)
+					w.WriteHeader(http.StatusNoContent)
+				}
+			})
+
+			project, _ := url.Parse("namespace/project.git")
+			uri := root.ResolveReference(project)
+			request = out.Request{
+				Source: pkg.Source{URI: uri.String()},
+				Params: out.Params{
+					Repository: "repo",
+					Comment:    out.Comment{Text: "3 tests failed", Mode: "sticky"},
+				},
+			}
+		})
+
+		It("Creates the note with a marker when there is none", func() {
+			notes = []gitlab.Note{noteBy(3, 5, "unrelated")}
+			_, err := command.Run(destination, request)
+			Expect(err).Should(BeNil())
+			Expect(created).To(Equal([]string{"<!-- gitlab-merge-request-resource:sticky -->\n3 tests failed"}))
+		})
+
+		It("Edits the existing note in place", func() {
+			notes = []gitlab.Note{noteBy(3, 5, "unrelated"), noteBy(7, 5, "<!-- gitlab-merge-request-resource:sticky -->\nold")}
+			_, err := command.Run(destination, request)
+			Expect(err).Should(BeNil())
+			Expect(created).To(BeEmpty())
+			Expect(updated[7]).To(Equal("<!-- gitlab-merge-request-resource:sticky -->\n3 tests failed"))
+		})
+
+		It("Ignores the notes of other users quoting the marker", func() {
+			notes = []gitlab.Note{noteBy(7, 8, "<!-- gitlab-merge-request-resource:sticky -->\nold")}
+			_, err := command.Run(destination, request)
+			Expect(err).Should(BeNil())
+			Expect(updated).To(BeEmpty())
+			Expect(created).To(Equal([]string{"<!-- gitlab-merge-request-resource:sticky -->\n3 tests failed"}))
+		})
+
+		It("Scopes the note to the status context", func() {
+			notes = []gitlab.Note{noteBy(7, 5, "<!-- gitlab-merge-request-resource:sticky -->\nold")}
+			request.Params.Context = "lint"
+			request.Params.Comment.PerContext = true
+			_, err := command.Run(destination, request)
+			Expect(err).Should(BeNil())
+			Expect(updated).To(BeEmpty())
+			Expect(created).To(Equal([]string{"<!-- gitlab-merge-request-resource:sticky:lint -->\n3 tests failed"}))
+		})
+
+		It("Deletes the note on success", func() {
+			notes = []gitlab.Note{noteBy(7, 5, "<!-- gitlab-merge-request-resource:sticky -->\nold")}
+			request.Params.Comment = out.Comment{Mode: "sticky", OnSuccess: "delete"}
+			request.Params.Status = "success"
+			mux.HandleFunc("/api/v4/projects/1/statuses/abc", func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("content-type", "application/json")
+				w.WriteHeader(http.StatusCreated)
+				w.Write([]byte(`{"id":1}`))
+			})
+			_, err := command.Run(destination, request)
+			Expect(err).Should(BeNil())
+			Expect(deleted).To(Equal([]int{7}))
+		})
+
+		It("Collapses the note on success", func() {
+			notes = []gitlab.Note{noteBy(7, 5, "<!-- gitlab-merge-request-resource:sticky -->\nold")}
+			request.Params.Comment = out.Comment{Mode: "sticky", OnSuccess: "collapse"}
+			request.Params.Status = "success"
+			mux.HandleFunc("/api/v4/projects/1/statuses/abc", func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("content-type", "application/json")
+				w.WriteHeader(http.StatusCreated)
+				w.Write([]byte(`{"id":1}`))
+			})
+			_, err := command.Run(destination, request)
+			Expect(err).Should(BeNil())
+			Expect(updated[7]).To(Equal("<!-- gitlab-merge-request-resource:sticky -->\n<details><summary>Resolved</summary>\n\nold\n</details>"))
+		})
+
+		It("Rejects an invalid mode", func() {
+			request.Params.Comment.Mode = "append"
+			_, err := command.Run(destination, request)
+			Expect(err).Should(MatchError("invalid value for comment mode: append"))
+		})
+
+	})
+
+	Describe("Templated comment", func() {
+
+		var (
+			posted  []string
+			status  bool
+			request out.Request
+		)
+
+		BeforeEach(func() {
+			posted = nil
+			status = false
+
+			mr := gitlab.MergeRequest{ID: 1, IID: 42, SHA: "abc", ProjectID: 1, SourceProjectID: 1, Title: "Add feature", WebURL: "https://gitlab.example.com/mr/42", Author: &gitlab.BasicUser{Name: "john", Username: "jdoe"}}
+			content, _ := json.Marshal(mr)
+
+			_ = os.Mkdir(path.Join(destination, "repo"), 0755)
+			_ = os.Mkdir(path.Join(destination, "repo", ".git"), 0755)
+			_ = os.WriteFile(path.Join(destination, "repo", ".git", "merge-request.json"), content, 0644)
+			_ = os.WriteFile(path.Join(destination, "lint.txt"), []byte("2 warnings"), 0644)
+
+			mux.HandleFunc("/api/v4/projects/1/statuses/abc", func(w http.ResponseWriter, r *http.Request) {
+				status = true
+				w.Header().Set("content-type", "application/json")
+				w.WriteHeader(http.StatusCreated)
+				w.Write([]byte(`{"id":1}`))
+			})
+			mux.HandleFunc("/api/v4/projects/1/merge_requests/42/notes", func(w http.ResponseWriter, r *http.Request) {
+				var options gitlab.CreateMergeRequestNoteOptions
+				_ = json.NewDecoder(r.Body).Decode(&options)
+				posted = append(posted, *options.Body)
+				w.Header().Set("content-type", "application/json")
+				w.WriteHeader(http.StatusCreated)
+				w.Write([]byte(`{"id":1}`))
+			})
+
+			project, _ := url.Parse("namespace/project.git")
+			uri := root.ResolveReference(project)
+			request = out.Request{
+				Source: pkg.Source{URI: uri.String()},
+				Params: out.Params{
+					Repository: "repo",
+					Status:     "failed",
+				},
+			}
+		})
+
+		It("Renders merge request fields, build metadata and files", func() {
+			request.Params.Comment = out.Comment{
+				Template: true,
+				Text:     "!{{.MergeRequest.IID}} {{.MergeRequest.Title}} by @{{.MergeRequest.AuthorUsername}}: {{.Files.lint}} in {{.Build.Job}} #{{.Build.Name}} {{.Build.URL}}",
+				Files:    map[string]string{"lint": "lint.txt"},
+			}
+			_, err := command.Run(destination, request)
+			Expect(err).Should(BeNil())
+			Expect(posted).To(Equal([]string{"!42 Add feature by @jdoe: 2 warnings in release #1 https://concourse-ci.company.ltd/teams/winner/pipelines/baltic/jobs/release/builds/1"}))
+		})
+
+		It("Fails before posting anything when a variable is missing", func() {
+			request.Params.Comment = out.Comment{
+				Template: true,
+				Text:     "{{.Files.unit}}",
+				Files:    map[string]string{"lint": "lint.txt"},
+			}
+			_, err := command.Run(destination, request)
+			Expect(err).Should(MatchError(ContainSubstring("rendering comment template")))
+			Expect(status).To(BeFalse())
+			Expect(posted).To(BeEmpty())
+		})
+
+	})
+
+	Describe("Add, remove and replace labels", func() {
+
+		var sent string
+
+		BeforeEach(func() {
+			sent = ""
+			mux.HandleFunc("/api/v4/projects/1/merge_requests/42", func(w http.ResponseWriter, r *http.Request) {
+				var options struct {
+					Labels string `json:"labels"`
+				}
+				body, _ := io.ReadAll(r.Body)
+				Expect(json.Unmarshal(body, &options)).To(Succeed())
+				sent = options.Labels
+				mr := gitlab.MergeRequest{ID: 1, IID: 42, SHA: "abc", ProjectID: 1, SourceProjectID: 1, Labels: []string{options.Labels}, Author: &gitlab.BasicUser{Name: "john"}}
+				output, _ := json.Marshal(mr)
+				w.Header().Set("content-type", "application/json")
+				w.WriteHeader(http.StatusOK)
+				w.Write(output)
+			})
+
+			mr := gitlab.MergeRequest{ID: 1, IID: 42, ProjectID: 1, SourceProjectID: 1, Labels: []string{"ci::running", "stage::review", "bug"}, Author: &gitlab.BasicUser{Name: "john"}}
+			content, _ := json.Marshal(mr)
+
+			_ = os.Mkdir(path.Join(destination, "repo"), 0755)
+			_ = os.Mkdir(path.Join(destination, "repo", ".git"), 0755)
+			_ = os.WriteFile(path.Join(destination, "repo", ".git", "merge-request.json"), content, 0644)
+		})
+
+		run := func(params out.Params) out.Response {
+			params.Repository = "repo"
+			response, err := command.Run(destination, out.Request{Params: params})
+			Expect(err).Should(BeNil())
+			return response
+		}
+
+		It("replaces a scoped label with the same scope", func() {
+			response := run(out.Params{AddLabels: []string{"ci::passed"}})
+			Expect(sent).To(Equal("stage::review,bug,ci::passed"))
+			Expect(response.Metadata).To(ContainElement(pkg.MetadataField{Name: "labels", Value: "stage::review,bug,ci::passed"}))
+		})
+
+		It("removes labels by name or pattern", func() {
+			run(out.Params{RemoveLabels: []string{"bug", "ci::*"}})
+			Expect(sent).To(Equal("stage::review"))
+		})
+
+		It("replaces all labels before adding", func() {
+			run(out.Params{ReplaceLabels: []string{"fresh"}, Labels: []string{"added"}})
+			Expect(sent).To(Equal("fresh,added"))
+		})
+
+		It("clears the labels", func() {
+			run(out.Params{ReplaceLabels: []string{}})
+			Expect(sent).To(Equal(""))
+		})
+
+	})
+
+	Describe("Approve", func() {
+
+		var (
+			approved bool
+			head     string
+			calls    []string
+		)
+
+		BeforeEach(func() {
+			approved, head, calls = false, "abc", nil
+			mux.HandleFunc("/api/v4/projects/1/merge_requests/42", func(w http.ResponseWriter, r *http.Request) {
+				mr := gitlab.MergeRequest{ID: 1, IID: 42, SHA: head, ProjectID: 1, SourceProjectID: 1}
+				output, _ := json.Marshal(mr)
+				w.Header().Set("content-type", "application/json")
+				w.Write(output)
+			})
+			mux.HandleFunc("/api/v4/projects/1/merge_requests/42/approvals", func(w http.ResponseWriter, r *http.Request) {
+				output, _ := json.Marshal(gitlab.MergeRequestApprovals{UserHasApproved: approved})
+				w.Header().Set("content-type", "application/json")
+				w.Write(output)
+			})
+			mux.HandleFunc("/api/v4/projects/1/merge_requests/42/approve", func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				calls = append(calls, "approve "+string(body))
+				output, _ := json.Marshal(gitlab.MergeRequestApprovals{UserHasApproved: true})
+				w.Header().Set("content-type", "application/json")
+				w.WriteHeader(http.StatusCreated)
+				w.Write(output)
+			})
+			mux.HandleFunc("/api/v4/projects/1/merge_requests/42/unapprove", func(w http.ResponseWriter, r *http.Request) {
+				calls = append(calls, "unapprove")
+				w.WriteHeader(http.StatusCreated)
+			})
+
+			mr := gitlab.MergeRequest{ID: 1, IID: 42, SHA: "abc", ProjectID: 1, SourceProjectID: 1, Author: &gitlab.BasicUser{Name: "john"}}
+			content, _ := json.Marshal(mr)
+
+			_ = os.Mkdir(path.Join(destination, "repo"), 0755)
+			_ = os.Mkdir(path.Join(destination, "repo", ".git"), 0755)
+			_ = os.WriteFile(path.Join(destination, "repo", ".git", "merge-request.json"), content, 0644)
+		})
+
+		run := func(approve bool, verify bool) error {
+			params := out.Params{Repository: "repo", Approve: &approve, ApproveVerifySHA: verify}
+			_, err := command.Run(destination, out.Request{Params: params})
+			return err
+		}
+
+		It("approves the merge request", func() {
+			Expect(run(true, false)).To(Succeed())
+			Expect(calls).To(Equal([]string{"approve {}"}))
+		})
+
+		It("does not approve twice", func() {
+			approved = true
+			Expect(run(true, false)).To(Succeed())
+			Expect(calls).To(BeEmpty())
+		})
+
+		It("revokes the approval", func() {
+			approved = true
+			Expect(run(false, false)).To(Succeed())
+			Expect(calls).To(Equal([]string{"unapprove"}))
+		})
+
+		It("does not revoke a missing approval", func() {
+			Expect(run(false, false)).To(Succeed())
+			Expect(calls).To(BeEmpty())
+		})
+
+		It("approves the tested sha", func() {
+			Expect(run(true, true)).To(Succeed())
+			Expect(calls).To(Equal([]string{`approve {"sha":"abc"}`}))
+		})
+
+		It("fails when the merge request has new commits", func() {
+			head = "def"
+			Expect(run(true, true)).To(MatchError(ContainSubstring("does not match the tested sha abc")))
+			Expect(calls).To(BeEmpty())
+		})
+
+	})
+
+	Describe("Merge", func() {
+
+		var (
+			status int
+			sent   map[string]interface{}
+		)
+
+		BeforeEach(func() {
+			status, sent = http.StatusOK, nil
+			mux.HandleFunc("/api/v4/projects/1/merge_requests/42/merge", func(w http.ResponseWriter, r *http.Request) {
+				Expect(r.Method).To(Equal(http.MethodPut))
+				body, _ := io.ReadAll(r.Body)
+				Expect(json.Unmarshal(body, &sent)).To(Succeed())
+				w.Header().Set("content-type", "application/json")
+				w.WriteHeader(status)
+				if status != http.StatusOK {
+					w.Write([]byte(`{"message":"refused"}`))
+					return
+				}
+				output, _ := json.Marshal(gitlab.MergeRequest{ID: 1, IID: 42, State: "merged"})
+				w.Write(output)
+			})
+
+			mr := gitlab.MergeRequest{ID: 1, IID: 42, SHA: "abc", Title: "Add feature", ProjectID: 1, SourceProjectID: 1, Author: &gitlab.BasicUser{Name: "john"}}
+			content, _ := json.Marshal(mr)
+
+			_ = os.Mkdir(path.Join(destination, "repo"), 0755)
+			_ = os.Mkdir(path.Join(destination, "repo", ".git"), 0755)
+			_ = os.WriteFile(path.Join(destination, "repo", ".git", "merge-request.json"), content, 0644)
+		})
+
+		run := func(merge out.Merge) error {
+			_, err := command.Run(destination, out.Request{Params: out.Params{Repository: "repo", Merge: &merge}})
+			return err
+		}
+
+		It("accepts the merge request with the tested sha", func() {
+			Expect(run(out.Merge{
+				Squash:                   gitlab.Bool(true),
+				ShouldRemoveSourceBranch: gitlab.Bool(false),
+				SquashCommitMessage:      "{{ .MergeRequest.Title }} (!{{ .MergeRequest.IID }})",
+			})).To(Succeed())
+			Expect(sent).To(HaveKeyWithValue("sha", "abc"))
+			Expect(sent).To(HaveKeyWithValue("squash", true))
+			Expect(sent).To(HaveKeyWithValue("should_remove_source_branch", false))
+			Expect(sent).To(HaveKeyWithValue("squash_commit_message", "Add feature (!42)"))
+			Expect(sent).NotTo(HaveKey("merge_when_pipeline_succeeds"))
+			Expect(sent).NotTo(HaveKey("merge_commit_message"))
+		})
+
+		It("keeps the choices of the merge request for the fields not given", func() {
+			Expect(run(out.Merge{})).To(Succeed())
+			Expect(sent).To(Equal(map[string]interface{}{"sha": "abc"}))
+		})
+
+		It("does not send the sha when the verification is disabled", func() {
+			verify := false
+			Expect(run(out.Merge{VerifySHA: &verify})).To(Succeed())
+			Expect(sent).NotTo(HaveKey("sha"))
+		})
+
+		It("fails before changing anything with an invalid commit message template", func() {
+			status := false
+			mux.HandleFunc("/api/v4/projects/1/statuses/abc", func(w http.ResponseWriter, r *http.Request) {
+				status = true
+			})
+			_, err := command.Run(destination, out.Request{Params: out.Params{
+				Repository: "repo",
+				Status:     "success",
+				Merge:      &out.Merge{CommitMessage: "{{ .Missing }}"},
+			}})
+			Expect(err).To(MatchError(ContainSubstring("merge commit message template")))
+			Expect(status).To(BeFalse())
+			Expect(sent).To(BeNil())
+		})
+
+		It("explains conflicts", func() {
+			status = http.StatusNotAcceptable
+			Expect(run(out.Merge{})).To(MatchError(ContainSubstring("merge request !42 cannot be merged, it has conflicts")))
+		})
+
+		It("explains a stale sha", func() {
+			status = http.StatusConflict
+			Expect(run(out.Merge{})).To(MatchError(ContainSubstring("its head is no longer the tested sha abc")))
+		})
+
+	})
+
+	Describe("Rebase", func() {
+
+		var (
+			polls      int
+			inProgress int
+			mergeError string
+			head       string
+			rebased    bool
+			merged     map[string]interface{}
+		)
+
+		BeforeEach(func() {
+			polls, inProgress, mergeError, head, rebased, merged = 0, 2, "", "abc", false, nil
+			command.WithPollInterval(time.Millisecond)
+
+			mux.HandleFunc("/api/v4/projects/1/merge_requests/42/rebase", func(w http.ResponseWriter, r *http.Request) {
+				Expect(r.Method).To(Equal(http.MethodPut))
+				rebased = true
+				w.Header().Set("content-type", "application/json")
+				w.WriteHeader(http.StatusAccepted)
+				w.Write([]byte(`{"rebase_in_progress":true}`))
+			})
+			mux.HandleFunc("/api/v4/projects/1/merge_requests/42", func(w http.ResponseWriter, r *http.Request) {
+				mr := gitlab.MergeRequest{ID: 1, IID: 42, SHA: head}
+				if rebased {
+					Expect(r.URL.Query().Get("include_rebase_in_progress")).To(Equal("true"))
+					polls++
+					mr.RebaseInProgress = polls <= inProgress
+					if !mr.RebaseInProgress {
+						mr.SHA, mr.MergeError = "def", mergeError
+					}
+				}
+				output, _ := json.Marshal(mr)
+				w.Header().Set("content-type", "application/json")
+				w.Write(output)
+			})
+
+			mr := gitlab.MergeRequest{ID: 1, IID: 42, SHA: "abc", ProjectID: 1, SourceProjectID: 1, Author: &gitlab.BasicUser{Name: "john"}}
+			content, _ := json.Marshal(mr)
+
+			_ = os.Mkdir(path.Join(destination, "repo"), 0755)
+			_ = os.Mkdir(path.Join(destination, "repo", ".git"), 0755)
+			_ = os.WriteFile(path.Join(destination, "repo", ".git", "merge-request.json"), content, 0644)
+		})
+
+		run := func(timeout string) (out.Response, error) {
+			params := out.Params{Repository: "repo", Rebase: true, RebaseTimeout: timeout}
+			return command.Run(destination, out.Request{Params: params})
+		}
+
+		It("waits for the rebase and exposes the new head sha", func() {
+			response, err := run("")
+			Expect(err).Should(BeNil())
+			Expect(polls).To(Equal(3))
+			Expect(response.Metadata).To(ContainElement(pkg.MetadataField{Name: "sha", Value: "def"}))
+		})
+
+		It("reports the merge error", func() {
+			mergeError = "Rebase failed: conflicts"
+			_, err := run("")
+			Expect(err).To(MatchError("rebase of merge request !42 failed: Rebase failed: conflicts"))
+		})
+
+		It("times out", func() {
+			inProgress = 1000
+			_, err := run("10ms")
+			Expect(err).To(MatchError(ContainSubstring("still in progress after 10ms")))
+		})
+
+		It("rejects an invalid timeout", func() {
+			_, err := run("soon")
+			Expect(err).To(MatchError(ContainSubstring("invalid value for rebase_timeout")))
+			Expect(polls).To(BeZero())
+		})
+
+		Context("followed by a merge", func() {
+
+			BeforeEach(func() {
+				mux.HandleFunc("/api/v4/projects/1/merge_requests/42/merge", func(w http.ResponseWriter, r *http.Request) {
+					body, _ := io.ReadAll(r.Body)
+					Expect(json.Unmarshal(body, &merged)).To(Succeed())
+					output, _ := json.Marshal(gitlab.MergeRequest{ID: 1, IID: 42, State: "opened"})
+					w.Header().Set("content-type", "application/json")
+					w.Write(output)
+				})
+			})
+
+			merge := func(merge out.Merge) error {
+				params := out.Params{Repository: "repo", Rebase: true, Merge: &merge}
+				_, err := command.Run(destination, out.Request{Params: params})
+				return err
+			}
+
+			It("rejects an immediate merge of the untested rebased head", func() {
+				Expect(merge(out.Merge{})).To(MatchError(ContainSubstring("requires merge_when_pipeline_succeeds")))
+				Expect(rebased).To(BeFalse())
+				Expect(merged).To(BeNil())
+			})
+
+			It("checks the tested sha before rebasing and merges the rebased head when its pipeline succeeds", func() {
+				Expect(merge(out.Merge{MergeWhenPipelineSucceeds: gitlab.Bool(true)})).To(Succeed())
+				Expect(rebased).To(BeTrue())
+				Expect(merged).To(HaveKeyWithValue("sha", "def"))
+				Expect(merged).To(HaveKeyWithValue("merge_when_pipeline_succeeds", true))
+			})
+
+			It("does not rebase a head which is not the tested sha", func() {
+				head = "xyz"
+				Expect(merge(out.Merge{MergeWhenPipelineSucceeds: gitlab.Bool(true)})).To(MatchError(ContainSubstring("does not match the tested sha abc")))
+				Expect(rebased).To(BeFalse())
+				Expect(merged).To(BeNil())
+			})
+
+		})
+
+	})
+
+	Describe("Update", func() {
+
+		var sent map[string]interface{}
+
+		BeforeEach(func() {
+			sent = nil
+			mux.HandleFunc("/api/v4/projects/1/merge_requests/42", func(w http.ResponseWriter, r *http.Request) {
+				Expect(r.Method).To(Equal(http.MethodPut))
+				body, _ := io.ReadAll(r.Body)
+				Expect(json.Unmarshal(body, &sent)).To(Succeed())
+				mr := gitlab.MergeRequest{
+					ID: 1, IID: 42, Title: "Draft: [JIRA-1] Add feature", State: "closed", Draft: true,
+					Assignees: []*gitlab.BasicUser{{ID: 7, Username: "alice"}, {ID: 8, Username: "bob"}},
+					Milestone: &gitlab.Milestone{ID: 3, Title: "v1.0"},
+				}
+				output, _ := json.Marshal(mr)
+				w.Header().Set("content-type", "application/json")
+				w.Write(output)
+			})
+			mux.HandleFunc("/api/v4/users", func(w http.ResponseWriter, r *http.Request) {
+				users := map[string]int{"alice": 7, "bob": 8}
+				result := []gitlab.User{}
+				if id, ok := users[r.URL.Query().Get("username")]; ok {
+					result = append(result, gitlab.User{ID: id, Username: r.URL.Query().Get("username")})
+				}
+				output, _ := json.Marshal(result)
+				w.Header().Set("content-type", "application/json")
+				w.Write(output)
+			})
+			mux.HandleFunc("/api/v4/projects/1/milestones", func(w http.ResponseWriter, r *http.Request) {
+				Expect(r.URL.Query().Get("include_parent_milestones")).To(Equal("true"))
+				output, _ := json.Marshal([]gitlab.Milestone{{ID: 3, Title: "v1.0"}})
+				w.Header().Set("content-type", "application/json")
+				w.Write(output)
+			})
+
+			mr := gitlab.MergeRequest{
+				ID: 1, IID: 42, ProjectID: 1, SourceProjectID: 1, Title: "WIP: Add feature", State: "opened",
+				Assignees: []*gitlab.BasicUser{{ID: 7, Username: "alice"}},
+				Author:    &gitlab.BasicUser{Name: "john"},
+			}
+			content, _ := json.Marshal(mr)
+
+			_ = os.Mkdir(path.Join(destination, "repo"), 0755)
+			_ = os.Mkdir(path.Join(destination, "repo", ".git"), 0755)
+			_ = os.WriteFile(path.Join(destination, "repo", ".git", "merge-request.json"), content, 0644)
+		})
+
+		run := func(update out.Update) (out.Response, error) {
+			return command.Run(destination, out.Request{Params: out.Params{Repository: "repo", Update: &update}})
+		}
+
+		It("updates the merge request fields and returns them in the metadata", func() {
+			milestone, draft := "v1.0", true
+			response, err := run(out.Update{
+				AddAssignees: []string{"@bob", "alice"},
+				Reviewers:    []string{"bob"},
+				Milestone:    &milestone,
+				Draft:        &draft,
+				State:        out.UpdateStateClose,
+				TitlePrefix:  "[JIRA-1] ",
+			})
+			Expect(err).Should(BeNil())
+			Expect(sent).To(Equal(map[string]interface{}{
+				"assignee_ids": []interface{}{7.0, 8.0},
+				"reviewer_ids": []interface{}{8.0},
+				"milestone_id": 3.0,
+				"state_event":  "close",
+				"title":        "WIP: [JIRA-1] Add feature",
+			}))
+			Expect(response.Metadata).To(ContainElements(
+				pkg.MetadataField{Name: "title", Value: "Draft: [JIRA-1] Add feature"},
+				pkg.MetadataField{Name: "state", Value: "closed"},
+				pkg.MetadataField{Name: "draft", Value: "true"},
+				pkg.MetadataField{Name: "assignees", Value: "alice,bob"},
+				pkg.MetadataField{Name: "milestone", Value: "v1.0"},
+			))
+		})
+
+		It("removes the draft marker and the milestone", func() {
+			milestone, draft := "", false
+			_, err := run(out.Update{Milestone: &milestone, Draft: &draft})
+			Expect(err).Should(BeNil())
+			Expect(sent).To(Equal(map[string]interface{}{"milestone_id": 0.0, "title": "Add feature"}))
+		})
+
+		It("does not update a merge request already draft and prefixed", func() {
+			draft := true
+			response, err := run(out.Update{Draft: &draft, TitlePrefix: "Add "})
+			Expect(err).Should(BeNil())
+			Expect(sent).To(BeNil())
+			Expect(response.Metadata).To(ContainElement(pkg.MetadataField{Name: "title", Value: "WIP: Add feature"}))
+		})
+
+		It("fails before any change for an unknown user", func() {
+			_, err := run(out.Update{Assignees: []string{"carol"}})
+			Expect(err).To(MatchError("user carol not found"))
+			Expect(sent).To(BeNil())
+		})
+
+		It("rejects an invalid state", func() {
+			_, err := run(out.Update{State: "merge"})
+			Expect(err).To(MatchError("invalid value for update state: merge"))
+		})
+
+		It("rejects setting and adding assignees together", func() {
+			_, err := run(out.Update{Assignees: []string{"alice"}, AddAssignees: []string{"bob"}})
+			Expect(err).To(MatchError(ContainSubstring("mutually exclusive")))
+		})
+
+	})
+
+	Describe("Review", func() {
+
+		var (
+			notes       []*gitlab.Note
+			discussions []map[string]interface{}
+			updated     []string
+		)
+
+		BeforeEach(func() {
+			notes, discussions, updated = nil, nil, nil
+
+			mux.HandleFunc("/api/v4/projects/1/merge_requests/42/versions", func(w http.ResponseWriter, r *http.Request) {
+				output, _ := json.Marshal([]gitlab.MergeRequestDiffVersion{{ID: 5}})
+				w.Header().Set("content-type", "application/json")
+				w.Write(output)
+			})
+			mux.HandleFunc("/api/v4/projects/1/merge_requests/42/versions/5", func(w http.ResponseWriter, r *http.Request) {
+				version := gitlab.MergeRequestDiffVersion{
+					ID: 5, BaseCommitSHA: "base", StartCommitSHA: "start", HeadCommitSHA: "head",
+					Diffs: []*gitlab.Diff{{
+						OldPath: "cmd.go", NewPath: "main.go", RenamedFile: true,
+						Diff: "@@ -1,3 +1,4 @@\n package main\n+import \"os\"\n \n-func main() {}\n+func main() { os.Exit(1) }\n",
+					}},
+				}
+				output, _ := json.Marshal(version)
+				w.Header().Set("content-type", "application/json")
+				w.Write(output)
+			})
+			mux.HandleFunc("/api/v4/projects/1/merge_requests/42/discussions", func(w http.ResponseWriter, r *http.Request) {
+				var discussion map[string]interface{}
+				body, _ := io.ReadAll(r.Body)
+				Expect(json.Unmarshal(body, &discussion)).To(Succeed())
+				discussions = append(discussions, discussion)
+				notes = append(notes, &gitlab.Note{ID: 100 + len(notes), Body: discussion["body"].(string)})
+				w.Header().Set("content-type", "application/json")
+				w.WriteHeader(http.StatusCreated)
+				w.Write([]byte(`{"id": "abc"}`))
+			})
+			mux.HandleFunc("/api/v4/projects/1/merge_requests/42/notes", func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("content-type", "application/json")
+				if r.Method == http.MethodPost {
+					var note gitlab.Note
+					body, _ := io.ReadAll(r.Body)
+					Expect(json.Unmarshal(body, &note)).To(Succeed())
+					note.ID = 100 + len(notes)
+					note.Author.ID = 5
+					notes = append(notes, &note)
+					w.WriteHeader(http.StatusCreated)
+					output, _ := json.Marshal(note)
+					w.Write(output)
+					return
+				}
+				output, _ := json.Marshal(notes)
+				w.Write(output)
+			})
+			mux.HandleFunc("/api/v4/projects/1/merge_requests/42/notes/", func(w http.ResponseWriter, r *http.Request) {
+				Expect(r.Method).To(Equal(http.MethodPut))
+				body, _ := io.ReadAll(r.Body)
+				updated = append(updated, string(body))
+				w.Header().Set("content-type", "application/json")
+				w.Write([]byte(`{}`))
+			})
+
+			mr := gitlab.MergeRequest{ID: 1, IID: 42, ProjectID: 1, SourceProjectID: 1, Author: &gitlab.BasicUser{Name: "john"}}
+			content, _ := json.Marshal(mr)
+
+			_ = os.Mkdir(path.Join(destination, "repo"), 0755)
+			_ = os.Mkdir(path.Join(destination, "repo", ".git"), 0755)
+			_ = os.WriteFile(path.Join(destination, "repo", ".git", "merge-request.json"), content, 0644)
+			_ = os.WriteFile(path.Join(destination, "gl-code-quality-report.json"), []byte(`[
+				{"description": "Exit in main", "check_name": "exit", "fingerprint": "golint:4b1e-9c.f/1", "severity": "major", "location": {"path": "main.go", "lines": {"begin": 4}}},
+				{"description": "Missing doc", "check_name": "doc", "fingerprint": "f2", "severity": "minor", "location": {"path": "main.go", "lines": {"begin": 1}}},
+				{"description": "Unused | value", "check_name": "unused", "fingerprint": "f3", "severity": "minor", "location": {"path": "util.go", "lines": {"begin": 9}}}
+			]`), 0644)
+		})
+
+		run := func() error {
+			params := out.Params{Repository: "repo", Review: &out.Review{FilePath: "gl-code-quality-report.json"}}
+			_, err := command.Run(destination, out.Request{Params: params})
+			return err
+		}
+
+		It("positions the findings on added lines and summarises the others", func() {
+			Expect(run()).To(Succeed())
+
+			Expect(discussions).To(HaveLen(1))
+			Expect(discussions[0]["body"]).To(Equal("<!-- gitlab-merge-request-resource:finding:golint:4b1e-9c.f/1 -->\n**major** `exit`: Exit in main"))
+			Expect(discussions[0]["position"]).To(And(
+				HaveKeyWithValue("base_sha", "base"),
+				HaveKeyWithValue("start_sha", "start"),
+				HaveKeyWithValue("head_sha", "head"),
+				HaveKeyWithValue("position_type", "text"),
+				HaveKeyWithValue("old_path", "cmd.go"),
+				HaveKeyWithValue("new_path", "main.go"),
+				HaveKeyWithValue("new_line", 4.0),
+			))
+
+			Expect(notes).To(HaveLen(2))
+			Expect(notes[1].Body).To(HavePrefix("<!-- gitlab-merge-request-resource:review -->\nFindings outside of the changes (2):"))
+			Expect(notes[1].Body).To(ContainSubstring("| `main.go:1` | minor | doc | Missing doc |"))
+			Expect(notes[1].Body).To(ContainSubstring("| `util.go:9` | minor | unused | Unused \\| value |"))
+		})
+
+		It("does not post the findings twice", func() {
+			Expect(run()).To(Succeed())
+			Expect(run()).To(Succeed())
+
+			Expect(discussions).To(HaveLen(1))
+			Expect(notes).To(HaveLen(2))
+			Expect(updated).To(BeEmpty())
+		})
+
+		It("does not edit a summary quoted by another user", func() {
+			quote := noteBy(7, 8, "<!-- gitlab-merge-request-resource:review -->\nNo findings outside of the changes.\n")
+			notes = append(notes, &quote)
+			Expect(run()).To(Succeed())
+
+			Expect(updated).To(BeEmpty())
+			Expect(notes).To(HaveLen(3))
+			Expect(notes[2].Body).To(HavePrefix("<!-- gitlab-merge-request-resource:review -->\nFindings outside of the changes (2):"))
+		})
+
+		It("fails before anything is posted with an invalid report", func() {
+			_ = os.WriteFile(path.Join(destination, "gl-code-quality-report.json"), []byte(`[{`), 0644)
+			Expect(run()).NotTo(Succeed())
+			Expect(notes).To(BeEmpty())
+		})
+
+	})
+
+	Describe("Test reports", func() {
+
+		var (
+			notes    []string
+			statuses []map[string]interface{}
+		)
+
+		BeforeEach(func() {
+			notes, statuses = nil, nil
+
+			mux.HandleFunc("/api/v4/projects/1/merge_requests/42/notes", func(w http.ResponseWriter, r *http.Request) {
+				var note gitlab.Note
+				body, _ := io.ReadAll(r.Body)
+				Expect(json.Unmarshal(body, &note)).To(Succeed())
+				notes = append(notes, note.Body)
+				w.Header().Set("content-type", "application/json")
+				w.WriteHeader(http.StatusCreated)
+				w.Write([]byte(`{}`))
+			})
+			mux.HandleFunc("/api/v4/projects/1/statuses/abc", func(w http.ResponseWriter, r *http.Request) {
+				var status map[string]interface{}
+				body, _ := io.ReadAll(r.Body)
+				Expect(json.Unmarshal(body, &status)).To(Succeed())
+				statuses = append(statuses, status)
+				w.Header().Set("content-type", "application/json")
+				w.WriteHeader(http.StatusCreated)
+				w.Write([]byte(`{}`))
+			})
+
+			mr := gitlab.MergeRequest{ID: 1, IID: 42, SHA: "abc", ProjectID: 1, SourceProjectID: 1, Author: &gitlab.BasicUser{Name: "john"}}
+			content, _ := json.Marshal(mr)
+
+			_ = os.Mkdir(path.Join(destination, "repo"), 0755)
+			_ = os.Mkdir(path.Join(destination, "repo", ".git"), 0755)
+			_ = os.WriteFile(path.Join(destination, "repo", ".git", "merge-request.json"), content, 0644)
+			_ = os.Mkdir(path.Join(destination, "reports"), 0755)
+			_ = os.WriteFile(path.Join(destination, "reports", "a.xml"), []byte(`<testsuite name="a"><testcase name="TestOk"/><testcase name="TestKo"><failure message="expected true"/></testcase></testsuite>`), 0644)
+			_ = os.WriteFile(path.Join(destination, "reports", "b.xml"), []byte(`<testsuites><testsuite name="b"><testcase name="TestSkip"><skipped/></testcase></testsuite></testsuites>`), 0644)
+		})
+
+		It("comments the summary after the comment and describes the status", func() {
+			request := out.Request{Params: out.Params{
+				Repository:             "repo",
+				Status:                 "failed",
+				Comment:                out.Comment{Text: "Build failed"},
+				TestReports:            []string{"reports/*.xml"},
+				TestReportsDescription: true,
+			}}
+
+			_, err := command.Run(destination, request)
+			Expect(err).Should(BeNil())
+			Expect(notes).To(Equal([]string{"Build failed\n\n" +
+				"**Test results**: 1 passed, 1 failed, 1 skipped (3 tests)\n\n" +
+				"| Failed test | Message |\n|---|---|\n" +
+				"| `a / TestKo` | expected true |"}))
+			Expect(statuses).To(HaveLen(1))
+			Expect(statuses[0]).To(HaveKeyWithValue("description", "1 passed, 1 failed, 1 skipped"))
+		})
+
+		It("keeps the given description", func() {
+			request := out.Request{Params: out.Params{
+				Repository:             "repo",
+				Status:                 "failed",
+				Description:            "unit tests",
+				TestReports:            []string{"reports/a.xml"},
+				TestReportsDescription: true,
+			}}
+
+			_, err := command.Run(destination, request)
+			Expect(err).Should(BeNil())
+			Expect(statuses[0]).To(HaveKeyWithValue("description", "unit tests"))
+			Expect(notes).To(Equal([]string{"**Test results**: 1 passed, 1 failed (2 tests)\n\n" +
+				"| Failed test | Message |\n|---|---|\n" +
+				"| `a / TestKo` | expected true |"}))
+		})
+
+		It("fails when no report matches", func() {
+			request := out.Request{Params: out.Params{Repository: "repo", Status: "success", TestReports: []string{"missing/*.xml"}}}
+
+			_, err := command.Run(destination, request)
+			Expect(err).To(MatchError("no test report matches missing/*.xml"))
+			Expect(statuses).To(BeEmpty())
+		})
+
+	})
+
+	Describe("Attachments", func() {
+
+		var (
+			notes   []string
+			uploads []string
+		)
+
+		BeforeEach(func() {
+			notes, uploads = nil, nil
+
+			mux.HandleFunc("/api/v4/projects/1/uploads", func(w http.ResponseWriter, r *http.Request) {
+				file, header, err := r.FormFile("file")
+				Expect(err).Should(BeNil())
+				content, _ := io.ReadAll(file)
+				uploads = append(uploads, header.Filename+":"+string(content))
+				upload := gitlab.ProjectFile{
+					Alt:      header.Filename,
+					URL:      "/uploads/0123/" + header.Filename,
+					Markdown: "![" + header.Filename + "](/uploads/0123/" + header.Filename + ")",
+				}
+				output, _ := json.Marshal(upload)
+				w.Header().Set("content-type", "application/json")
+				w.WriteHeader(http.StatusCreated)
+				w.Write(output)
+			})
+			mux.HandleFunc("/api/v4/projects/1/merge_requests/42/notes", func(w http.ResponseWriter, r *http.Request) {
+				var note gitlab.Note
+				body, _ := io.ReadAll(r.Body)
+				Expect(json.Unmarshal(body, &note)).To(Succeed())
+				notes = append(notes, note.Body)
+				w.Header().Set("content-type", "application/json")
+				w.WriteHeader(http.StatusCreated)
+				w.Write([]byte(`{}`))
+			})
+
+			mr := gitlab.MergeRequest{ID: 1, IID: 42, ProjectID: 1, SourceProjectID: 1, Author: &gitlab.BasicUser{Name: "john"}}
+			content, _ := json.Marshal(mr)
+
+			_ = os.Mkdir(path.Join(destination, "repo"), 0755)
+			_ = os.Mkdir(path.Join(destination, "repo", ".git"), 0755)
+			_ = os.WriteFile(path.Join(destination, "repo", ".git", "merge-request.json"), content, 0644)
+			_ = os.Mkdir(path.Join(destination, "screenshots"), 0755)
+			_ = os.WriteFile(path.Join(destination, "screenshots", "home.png"), []byte("home"), 0644)
+			_ = os.WriteFile(path.Join(destination, "screenshots", "login.png"), []byte("login"), 0644)
+		})
+
+		It("uploads the files and links them in the comment", func() {
+			var attachments out.Attachments
+			Expect(json.Unmarshal([]byte(`["screenshots/*.png"]`), &attachments)).To(Succeed())
+
+			request := out.Request{Params: out.Params{
+				Repository:  "repo",
+				Attachments: &attachments,
+				Comment: out.Comment{
+					Template: true,
+					Text:     `Home: {{ index .Attachments "screenshots/home.png" }}{{ range $file, $link := .Attachments }} {{ $file }}{{ end }}`,
+				},
+			}}
+
+			_, err := command.Run(destination, request)
+			Expect(err).Should(BeNil())
+			Expect(uploads).To(Equal([]string{"home.png:home", "login.png:login"}))
+			Expect(notes).To(Equal([]string{"Home: ![home.png](/uploads/0123/home.png) screenshots/home.png screenshots/login.png"}))
+		})
+
+		It("fails before uploading with an invalid comment template", func() {
+			attachments := out.Attachments{Files: []string{"screenshots/*.png"}}
+			request := out.Request{Params: out.Params{
+				Repository:  "repo",
+				Attachments: &attachments,
+				Comment:     out.Comment{Template: true, Text: `{{ index .Attachments "screenshots/home.png" }} {{ .Missing }}`},
+			}}
+
+			_, err := command.Run(destination, request)
+			Expect(err).To(MatchError(ContainSubstring("rendering comment template")))
+			Expect(uploads).To(BeEmpty())
+			Expect(notes).To(BeEmpty())
+		})
+
+		It("refuses files larger than the maximum size before uploading", func() {
+			var attachments out.Attachments
+			Expect(json.Unmarshal([]byte(`{"files": ["screenshots/*.png"], "max_size": 4}`), &attachments)).To(Succeed())
+
+			_, err := command.Run(destination, out.Request{Params: out.Params{Repository: "repo", Attachments: &attachments}})
+			Expect(err).To(MatchError(ContainSubstring("login.png is larger than 4 bytes")))
+			Expect(uploads).To(BeEmpty())
+		})
+
+		It("fails when a pattern matches no file", func() {
+			attachments := out.Attachments{Files: []string{"reports/*.html"}}
+
+			_, err := command.Run(destination, out.Request{Params: out.Params{Repository: "repo", Attachments: &attachments}})
+			Expect(err).To(MatchError("no attachment matches reports/*.html"))
+		})
+
+	})
+
+	Describe("Fork merge request", func() {
+
+		var (
+			calls        []string
+			targetStatus int
+			sourceStatus int
+		)
+
+		BeforeEach(func() {
+			calls, targetStatus, sourceStatus = nil, http.StatusCreated, http.StatusCreated
+
+			mux.HandleFunc("/api/v4/projects/1/merge_requests/42", func(w http.ResponseWriter, r *http.Request) {
+				calls = append(calls, "labels on target")
+				output, _ := json.Marshal(gitlab.MergeRequest{ID: 1, IID: 42, Labels: []string{"ci::passed"}})
+				w.Header().Set("content-type", "application/json")
+				w.Write(output)
+			})
+			mux.HandleFunc("/api/v4/projects/1/merge_requests/42/notes", func(w http.ResponseWriter, r *http.Request) {
+				calls = append(calls, "note on target")
+				w.Header().Set("content-type", "application/json")
+				w.WriteHeader(http.StatusCreated)
+				w.Write([]byte(`{}`))
+			})
+			mux.HandleFunc("/api/v4/projects/1/statuses/abc", func(w http.ResponseWriter, r *http.Request) {
+				calls = append(calls, "status on target")
+				w.Header().Set("content-type", "application/json")
+				w.WriteHeader(targetStatus)
+				w.Write([]byte(`{"message":"target"}`))
+			})
+			mux.HandleFunc("/api/v4/projects/2/statuses/abc", func(w http.ResponseWriter, r *http.Request) {
+				calls = append(calls, "status on source")
+				w.Header().Set("content-type", "application/json")
+				w.WriteHeader(sourceStatus)
+				w.Write([]byte(`{"message":"source"}`))
+			})
+			mux.HandleFunc("/api/v4/projects/2/", func(w http.ResponseWriter, r *http.Request) {
+				Fail("unexpected call to the fork " + r.URL.Path)
+			})
+
+			mr := gitlab.MergeRequest{ID: 1, IID: 42, SHA: "abc", ProjectID: 1, TargetProjectID: 1, SourceProjectID: 2, Author: &gitlab.BasicUser{Name: "john"}}
+			content, _ := json.Marshal(mr)
+
+			_ = os.Mkdir(path.Join(destination, "repo"), 0755)
+			_ = os.Mkdir(path.Join(destination, "repo", ".git"), 0755)
+			_ = os.WriteFile(path.Join(destination, "repo", ".git", "merge-request.json"), content, 0644)
+		})
+
+		run := func(statusProject string) error {
+			request := out.Request{
+				Source: pkg.Source{StatusProject: statusProject},
+				Params: out.Params{
+					Repository: "repo",
+					Status:     "success",
+					AddLabels:  []string{"ci::passed"},
+					Comment:    out.Comment{Text: "passed"},
+				},
+			}
+			_, err := command.Run(destination, request)
+			return err
+		}
+
+		It("posts everything on the target project", func() {
+			Expect(run("")).To(Succeed())
+			Expect(calls).To(Equal([]string{"status on target", "labels on target", "note on target"}))
+		})
+
+		It("falls back to the source project when the target refuses the status", func() {
+			for _, status := range []int{http.StatusForbidden, http.StatusNotFound} {
+				calls, targetStatus = nil, status
+				Expect(run("")).To(Succeed())
+				Expect(calls).To(Equal([]string{"status on target", "status on source", "labels on target", "note on target"}))
+			}
+		})
+
+		It("does not fall back on other errors of the target", func() {
+			targetStatus = http.StatusBadRequest
+			Expect(run("")).To(MatchError(ContainSubstring("400")))
+			Expect(calls).To(Equal([]string{"status on target"}))
+		})
+
+		It("reports both errors when the fallback fails", func() {
+			targetStatus, sourceStatus = http.StatusNotFound, http.StatusForbidden
+			err := run("")
+			Expect(err).To(MatchError(ContainSubstring("source project 2")))
+			Expect(err).To(MatchError(ContainSubstring("403")))
+			Expect(err).To(MatchError(ContainSubstring("404")))
+			Expect(calls).To(Equal([]string{"status on target", "status on source"}))
+		})
+
+		It("sets the status on the source project", func() {
+			Expect(run(pkg.StatusProjectSource)).To(Succeed())
+			Expect(calls).To(Equal([]string{"status on source", "labels on target", "note on target"}))
+		})
+
+		It("does not fall back when the status project is the target", func() {
+			targetStatus = http.StatusNotFound
+			Expect(run(pkg.StatusProjectTarget)).NotTo(Succeed())
+			Expect(calls).To(Equal([]string{"status on target"}))
+		})
+
+		It("rejects an invalid status project", func() {
+			Expect(run("fork")).To(MatchError("invalid value for status_project: fork"))
+		})
+
+		It("rejects an invalid status project before any change without a status", func() {
+			request := out.Request{
+				Source: pkg.Source{StatusProject: "fork"},
+				Params: out.Params{Repository: "repo", Comment: out.Comment{Text: "passed"}},
+			}
+			_, err := command.Run(destination, request)
+			Expect(err).To(MatchError("invalid value for status_project: fork"))
+			Expect(calls).To(BeEmpty())
+		})
+
+	})
+
+	Describe("Dry run", func() {
+
+		var (
+			mutations int
+			output    *bytes.Buffer
+		)
+
+		BeforeEach(func() {
+			mutations, output = 0, &bytes.Buffer{}
+
+			command.WithDryRunOutput(output)
+
+			mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodGet {
+					mutations++
+				}
+				w.WriteHeader(http.StatusNotFound)
+			})
+			mux.HandleFunc("/api/v4/projects/1/merge_requests/42/notes", func(w http.ResponseWriter, r *http.Request) {
+				Expect(r.Method).To(Equal(http.MethodGet))
+				w.Header().Set("content-type", "application/json")
+				w.Write([]byte(`[]`))
+			})
+
+			mr := gitlab.MergeRequest{ID: 1, IID: 42, SHA: "abc", ProjectID: 1, SourceProjectID: 1, Title: "Add feature", Labels: []string{"ci::running"}, Author: &gitlab.BasicUser{Name: "john"}}
+			content, _ := json.Marshal(mr)
+
+			_ = os.Mkdir(path.Join(destination, "repo"), 0755)
+			_ = os.Mkdir(path.Join(destination, "repo", ".git"), 0755)
+			_ = os.WriteFile(path.Join(destination, "repo", ".git", "merge-request.json"), content, 0644)
+		})
+
+		It("logs the mutations instead of calling gitlab", func() {
+			request := out.Request{Params: out.Params{
+				Repository: "repo",
+				Status:     "success",
+				Context:    "unit",
+				AddLabels:  []string{"ci::passed"},
+				Comment:    out.Comment{Text: "passed", Mode: out.CommentModeSticky},
+				Update:     &out.Update{TitlePrefix: "[ready] "},
+				Merge:      &out.Merge{},
+				DryRun:     true,
+			}}
+
+			response, err := command.Run(destination, request)
+			Expect(err).Should(BeNil())
+			Expect(mutations).To(BeZero())
+			Expect(response.Version.ID).To(Equal(42))
+			Expect(response.Metadata).To(ContainElements(
+				pkg.MetadataField{Name: "title", Value: "[ready] Add feature"},
+				pkg.MetadataField{Name: "labels", Value: "ci::passed"},
+			))
+
+			var entries []pkg.DryRunEntry
+			decoder := json.NewDecoder(output)
+			for decoder.More() {
+				var entry pkg.DryRunEntry
+				Expect(decoder.Decode(&entry)).To(Succeed())
+				Expect(entry.DryRun).To(BeTrue())
+				entries = append(entries, entry)
+			}
+
+			Expect(entries).To(HaveLen(5))
+			Expect(entries[0].Method).To(Equal(http.MethodPost))
+			Expect(entries[0].URL).To(Equal(root.String() + "/api/v4/projects/1/statuses/abc"))
+			Expect(string(entries[0].Body)).To(ContainSubstring(`"name":"unit"`))
+			Expect(entries[1].URL).To(HaveSuffix("/projects/1/merge_requests/42"))
+			Expect(string(entries[1].Body)).To(Equal(`{"labels":"ci::passed"}`))
+			Expect(string(entries[2].Body)).To(Equal(`{"title":"[ready] Add feature"}`))
+			Expect(entries[3].URL).To(HaveSuffix("/projects/1/merge_requests/42/notes"))
+			Expect(entries[4].Method).To(Equal(http.MethodPut))
+			Expect(entries[4].URL).To(HaveSuffix("/projects/1/merge_requests/42/merge"))
+		})
+
+	})
+
+	Describe("Without a prior get", func() {
+
+		var (
+			statuses []string
+			opened   []gitlab.MergeRequest
+		)
+
+		BeforeEach(func() {
+			statuses = nil
+			opened = []gitlab.MergeRequest{{IID: 42, State: "opened"}}
+
+			mux.HandleFunc("/api/v4/projects/namespace/project/merge_requests/42", func(w http.ResponseWriter, r *http.Request) {
+				mr := gitlab.MergeRequest{ID: 1, IID: 42, SHA: "abc", ProjectID: 1, SourceProjectID: 1, Author: &gitlab.BasicUser{Name: "john"}}
+				output, _ := json.Marshal(mr)
+				w.Header().Set("content-type", "application/json")
+				w.Write(output)
+			})
+			mux.HandleFunc("/api/v4/projects/namespace/project/merge_requests", func(w http.ResponseWriter, r *http.Request) {
+				Expect(r.URL.Query().Get("source_branch")).To(Equal("feature"))
+				Expect(r.URL.Query().Get("state")).To(Equal("opened"))
+				output, _ := json.Marshal(opened)
+				w.Header().Set("content-type", "application/json")
+				w.Write(output)
+			})
+			mux.HandleFunc("/api/v4/projects/namespace/project/repository/commits/def/merge_requests", func(w http.ResponseWriter, r *http.Request) {
+				output, _ := json.Marshal(append(opened, gitlab.MergeRequest{IID: 41, State: "merged"}))
+				w.Header().Set("content-type", "application/json")
+				w.Write(output)
+			})
+			mux.HandleFunc("/api/v4/projects/1/statuses/", func(w http.ResponseWriter, r *http.Request) {
+				statuses = append(statuses, path.Base(r.URL.Path))
+				w.Header().Set("content-type", "application/json")
+				w.WriteHeader(http.StatusCreated)
+				w.Write([]byte(`{}`))
+			})
+		})
+
+		run := func(params out.Params) (out.Response, error) {
+			project, _ := url.Parse("namespace/project.git")
+			params.Status = "success"
+			request := out.Request{Source: pkg.Source{URI: root.ResolveReference(project).String()}, Params: params}
+			return command.Run(destination, request)
+		}
+
+		It("resolves the merge request by iid", func() {
+			response, err := run(out.Params{IID: 42})
+			Expect(err).Should(BeNil())
+			Expect(response.Version.ID).To(Equal(42))
+			Expect(statuses).To(Equal([]string{"abc"}))
+		})
+
+		It("resolves the merge request by source branch", func() {
+			response, err := run(out.Params{SourceBranch: "feature"})
+			Expect(err).Should(BeNil())
+			Expect(response.Version.ID).To(Equal(42))
+			Expect(statuses).To(Equal([]string{"abc"}))
+		})
+
+		It("resolves the merge request by commit and sets the status on it", func() {
+			response, err := run(out.Params{SHA: "def"})
+			Expect(err).Should(BeNil())
+			Expect(response.Version.ID).To(Equal(42))
+			Expect(statuses).To(Equal([]string{"def"}))
+		})
+
+		It("fails without an opened merge request", func() {
+			opened = nil
+			_, err := run(out.Params{SourceBranch: "feature"})
+			Expect(err).To(MatchError("no opened merge request found for source branch feature"))
+		})
+
+		It("fails with several opened merge requests", func() {
+			opened = append(opened, gitlab.MergeRequest{IID: 43, State: "opened"})
+			_, err := run(out.Params{SHA: "def"})
+			Expect(err).To(MatchError("2 opened merge requests found for commit def, use iid instead"))
+		})
+
+		It("rejects several identifiers", func() {
+			_, err := run(out.Params{IID: 42, SHA: "def"})
+			Expect(err).To(MatchError("iid, source_branch and sha are mutually exclusive"))
+		})
+
+	})
+
+})
+
+// noteBy returns a note written by the user with the given id.
+func noteBy(id int, author int, body string) gitlab.Note {
+	note := gitlab.Note{ID: id, Body: body}
+	note.Author.ID = author
+	return note
+}
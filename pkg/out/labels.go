@@ -0,0 +1,74 @@
+package out
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// applyLabels computes the labels of the merge request after the update: the replace_labels are set first,
+// then the remove_labels (exact names or glob patterns such as ci::*) are removed and the labels and add_labels are added.
+// Adding a scoped label removes the other labels of the same scope, like GitLab does.
+func applyLabels(current []string, params Params) []string {
+	labels := make([]string, 0, len(current))
+	for _, label := range current {
+		// exclude empty string when there is no tags
+		// this should be fixed in go-gitlab
+		if label != "" {
+			labels = append(labels, label)
+		}
+	}
+
+	if params.ReplaceLabels != nil {
+		labels = append([]string{}, params.ReplaceLabels...)
+	}
+
+	labels = filterLabels(labels, func(label string) bool {
+		for _, pattern := range params.RemoveLabels {
+			if ok, _ := filepath.Match(pattern, label); ok || pattern == label {
+				return false
+			}
+		}
+		return true
+	})
+
+	for _, added := range append(append([]string{}, params.Labels...), params.AddLabels...) {
+		if scope := labelScope(added); scope != "" {
+			labels = filterLabels(labels, func(label string) bool {
+				return label == added || labelScope(label) != scope
+			})
+		}
+		if !containsLabel(labels, added) {
+			labels = append(labels, added)
+		}
+	}
+
+	return labels
+}
+
+// labelScope returns the scope of a scoped label (the part before the last ::) or an empty string.
+func labelScope(label string) string {
+	i := strings.LastIndex(label, "::")
+	if i <= 0 {
+		return ""
+	}
+	return label[:i]
+}
+
+func filterLabels(labels []string, keep func(string) bool) []string {
+	filtered := make([]string, 0, len(labels))
+	for _, label := range labels {
+		if keep(label) {
+			filtered = append(filtered, label)
+		}
+	}
+	return filtered
+}
+
+func containsLabel(labels []string, label string) bool {
+	for _, l := range labels {
+		if l == label {
+			return true
+		}
+	}
+	return false
+}
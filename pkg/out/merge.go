@@ -0,0 +1,87 @@
+package out
+
+import (
+	"errors"
+	"fmt"
+	"github.com/xanzy/go-gitlab"
+	"net/http"
+)
+
+// mergeOptions renders the commit message templates of the merge params, so that an invalid template fails before
+// anything is changed. Only the fields given in the params are sent, gitlab keeping the choices of the merge
+// request for the others. It returns nil without merge.
+func mergeOptions(request Request, mr gitlab.MergeRequest) (*gitlab.AcceptMergeRequestOptions, error) {
+	merge := request.Params.Merge
+	if merge == nil {
+		return nil, nil
+	}
+
+	options := gitlab.AcceptMergeRequestOptions{
+		Squash:                    merge.Squash,
+		ShouldRemoveSourceBranch:  merge.ShouldRemoveSourceBranch,
+		MergeWhenPipelineSucceeds: merge.MergeWhenPipelineSucceeds,
+	}
+
+	variables := templateVariables(request, mr)
+	if merge.CommitMessage != "" {
+		message, err := renderTemplate("merge commit message", merge.CommitMessage, variables)
+		if err != nil {
+			return nil, err
+		}
+		options.MergeCommitMessage = &message
+	}
+	if merge.SquashCommitMessage != "" {
+		message, err := renderTemplate("squash commit message", merge.SquashCommitMessage, variables)
+		if err != nil {
+			return nil, err
+		}
+		options.SquashCommitMessage = &message
+	}
+
+	return &options, nil
+}
+
+// acceptMergeRequest merges the merge request, or schedules its merge when the pipeline succeeds.
+func (command *Command) acceptMergeRequest(request Request, mr gitlab.MergeRequest, options *gitlab.AcceptMergeRequestOptions) error {
+	if options == nil {
+		return nil
+	}
+
+	if request.Params.Merge.GetVerifySHA() {
+		options.SHA = &mr.SHA
+	}
+
+	_, _, err := command.client.MergeRequests.AcceptMergeRequest(mr.ProjectID, mr.IID, options)
+	if err != nil {
+		return mergeError(mr, err)
+	}
+
+	command.logger.Info("accepted merge request", "iid", mr.IID, "when_pipeline_succeeds", request.Params.Merge.WhenPipelineSucceeds())
+	return nil
+}
+
+// mergeError explains why gitlab refused to merge the merge request, according to the status of the response.
+func mergeError(mr gitlab.MergeRequest, err error) error {
+	var response *gitlab.ErrorResponse
+	if !errors.As(err, &response) || response.Response == nil {
+		return err
+	}
+
+	var reason string
+	switch response.Response.StatusCode {
+	case http.StatusUnauthorized:
+		reason = "the user is not allowed to merge it, or it is missing approvals"
+	case http.StatusMethodNotAllowed:
+		reason = "it is not mergeable, for example a draft, closed, missing approvals or waiting for its pipeline"
+	case http.StatusNotAcceptable:
+		reason = "it has conflicts with the target branch"
+	case http.StatusConflict:
+		reason = fmt.Sprintf("its head is no longer the tested sha %s", mr.SHA)
+	case http.StatusUnprocessableEntity:
+		reason = "the merge failed"
+	default:
+		return err
+	}
+
+	return fmt.Errorf("merge request !%d cannot be merged, %s: %w", mr.IID, reason, err)
+}
@@ -1,10 +1,17 @@
 package out
 
 import (
+	"encoding/json"
+	"fmt"
 	"github.com/samcontesse/gitlab-merge-request-resource/pkg"
+	"github.com/samcontesse/gitlab-merge-request-resource/pkg/coverage"
+	"github.com/samcontesse/gitlab-merge-request-resource/pkg/findings"
 	"os"
 	"path"
+	"path/filepath"
 	"strings"
+	"text/template"
+	"time"
 )
 
 type Request struct {
@@ -12,21 +19,131 @@ type Request struct {
 	Params Params     `json:"params"`
 }
 
+// DryRun tells whether the mutations must be logged instead of sent, as asked by the source or the params.
+func (request Request) DryRun() bool {
+	return request.Source.DryRun || request.Params.DryRun
+}
+
 type Response struct {
 	Version  pkg.Version  `json:"version"`
 	Metadata pkg.Metadata `json:"metadata"`
 }
 
 type Params struct {
-	Repository string   `json:"repository"`
-	Status     string   `json:"status"`
-	Labels     []string `json:"labels"`
-	Comment    Comment  `json:"comment"`
+	Repository             string       `json:"repository"`
+	IID                    int          `json:"iid"`
+	SourceBranch           string       `json:"source_branch"`
+	SHA                    string       `json:"sha"`
+	Status                 string       `json:"status"`
+	Context                string       `json:"context"`
+	Name                   string       `json:"name"`
+	Description            string       `json:"description"`
+	Coverage               Coverage     `json:"coverage"`
+	Labels                 []string     `json:"labels"`
+	AddLabels              []string     `json:"add_labels"`
+	RemoveLabels           []string     `json:"remove_labels"`
+	ReplaceLabels          []string     `json:"replace_labels"`
+	Comment                Comment      `json:"comment"`
+	Approve                *bool        `json:"approve"`
+	ApproveVerifySHA       bool         `json:"approve_verify_sha"`
+	Attachments            *Attachments `json:"attachments"`
+	TestReports            []string     `json:"test_reports"`
+	TestReportsDescription bool         `json:"test_reports_description"`
+	Review                 *Review      `json:"review"`
+	Update                 *Update      `json:"update"`
+	Rebase                 bool         `json:"rebase"`
+	RebaseTimeout          string       `json:"rebase_timeout"`
+	Merge                  *Merge       `json:"merge"`
+	DryRun                 bool         `json:"dry_run"`
+}
+
+// GetRebaseTimeout returns how long to wait for the rebase to finish, five minutes by default.
+func (params Params) GetRebaseTimeout() (time.Duration, error) {
+	if params.RebaseTimeout == "" {
+		return 5 * time.Minute, nil
+	}
+	timeout, err := time.ParseDuration(params.RebaseTimeout)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value for rebase_timeout: %v", err)
+	}
+	return timeout, nil
+}
+
+// ValidateMergeRequest checks that the merge request is identified by at most one of iid, source_branch and sha.
+func (params Params) ValidateMergeRequest() error {
+	count := 0
+	for _, set := range []bool{params.IID != 0, params.SourceBranch != "", params.SHA != ""} {
+		if set {
+			count++
+		}
+	}
+	if count > 1 {
+		return fmt.Errorf("iid, source_branch and sha are mutually exclusive")
+	}
+	return nil
+}
+
+// ValidateMerge checks that a merge after a rebase waits for the pipeline of the rebased head, which is not the
+// tested commit.
+func (params Params) ValidateMerge() error {
+	if params.Rebase && params.Merge != nil && !params.Merge.WhenPipelineSucceeds() {
+		return fmt.Errorf("merge after rebase requires merge_when_pipeline_succeeds, the rebased head being untested")
+	}
+	return nil
+}
+
+// GetStatusName returns the name of the commit status, either given by the context (or its name alias) param
+// or the default status name of the source. Concourse build variables are expanded.
+func (params Params) GetStatusName(source pkg.Source) string {
+	switch {
+	case params.Context != "":
+		return pkg.ExpandBuildVariables(params.Context)
+	case params.Name != "":
+		return pkg.ExpandBuildVariables(params.Name)
+	}
+	return source.GetStatusName()
 }
 
 type Comment struct {
-	FilePath string `json:"file"`
-	Text     string `json:"text"`
+	FilePath   string            `json:"file"`
+	Text       string            `json:"text"`
+	Template   bool              `json:"template"`
+	Files      map[string]string `json:"files"`
+	Mode       string            `json:"mode"`
+	PerContext bool              `json:"per_context"`
+	OnSuccess  string            `json:"on_success"`
+}
+
+// Comment modes, a sticky comment is edited in place by the following puts instead of adding a new one.
+const (
+	CommentModeCreate = "create"
+	CommentModeSticky = "sticky"
+)
+
+// What happens to a sticky comment when the status is success.
+const (
+	CommentOnSuccessDelete   = "delete"
+	CommentOnSuccessCollapse = "collapse"
+)
+
+func (comment Comment) Validate() error {
+	switch comment.Mode {
+	case "", CommentModeCreate, CommentModeSticky:
+	default:
+		return fmt.Errorf("invalid value for comment mode: %v", comment.Mode)
+	}
+
+	switch comment.OnSuccess {
+	case "":
+	case CommentOnSuccessDelete, CommentOnSuccessCollapse:
+		if comment.Mode != CommentModeSticky {
+			return fmt.Errorf("comment on_success requires the %s mode", CommentModeSticky)
+		}
+	default:
+		return fmt.Errorf("invalid value for comment on_success: %v", comment.OnSuccess)
+	}
+
+	return nil
 }
 
 func (comment Comment) ReadContent(folder string) (string, error) {
@@ -51,3 +168,245 @@ func (comment Comment) ReadContent(folder string) (string, error) {
 
 	return commentContent, nil
 }
+
+// Coverage is either a percentage or a coverage report, given as a path or as an object with a file and a format.
+type Coverage struct {
+	Value    *float64 `json:"value,omitempty"`
+	FilePath string   `json:"file,omitempty"`
+	Format   string   `json:"format,omitempty"`
+}
+
+func (c *Coverage) UnmarshalJSON(data []byte) error {
+	var value float64
+	if err := json.Unmarshal(data, &value); err == nil {
+		c.Value = &value
+		return nil
+	}
+
+	var file string
+	if err := json.Unmarshal(data, &file); err == nil {
+		c.FilePath = file
+		return nil
+	}
+
+	type plain Coverage
+	return json.Unmarshal(data, (*plain)(c))
+}
+
+// Read returns the coverage percentage, parsing the report found in folder if needed.
+// It returns nil when no coverage is configured.
+func (c Coverage) Read(folder string) (*float64, error) {
+	if c.Value != nil || c.FilePath == "" {
+		return c.Value, nil
+	}
+
+	content, err := os.ReadFile(path.Join(folder, c.FilePath))
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := coverage.Parse(content, c.Format)
+	if err != nil {
+		return nil, err
+	}
+
+	return &value, nil
+}
+
+// Attachments are the files uploaded to the project and linked in the comment, given as glob patterns
+// relative to the out directory, or as an object with the patterns and the maximum size of a file in bytes.
+type Attachments struct {
+	Files   []string `json:"files"`
+	MaxSize int64    `json:"max_size"`
+}
+
+// DefaultAttachmentMaxSize is the maximum size of an attachment when none is given, 10 MiB.
+const DefaultAttachmentMaxSize = 10 << 20
+
+func (attachments *Attachments) UnmarshalJSON(data []byte) error {
+	var files []string
+	if err := json.Unmarshal(data, &files); err == nil {
+		attachments.Files = files
+		return nil
+	}
+
+	type plain Attachments
+	return json.Unmarshal(data, (*plain)(attachments))
+}
+
+// Resolve returns the paths relative to folder of the files matching the patterns. It fails when a pattern
+// matches no file or when a file is larger than the maximum size.
+func (attachments *Attachments) Resolve(folder string) ([]string, error) {
+	if attachments == nil {
+		return nil, nil
+	}
+
+	maxSize := attachments.MaxSize
+	if maxSize == 0 {
+		maxSize = DefaultAttachmentMaxSize
+	}
+
+	var files []string
+	for _, pattern := range attachments.Files {
+		matches, err := filepath.Glob(filepath.Join(folder, pattern))
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for attachments: %v", err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no attachment matches %s", pattern)
+		}
+		for _, match := range matches {
+			info, err := os.Stat(match)
+			if err != nil {
+				return nil, err
+			}
+			if info.IsDir() {
+				continue
+			}
+			if info.Size() > maxSize {
+				return nil, fmt.Errorf("attachment %s is larger than %d bytes", match, maxSize)
+			}
+			file, _ := filepath.Rel(folder, match)
+			files = append(files, filepath.ToSlash(file))
+		}
+	}
+
+	return files, nil
+}
+
+// Review is a SARIF or GitLab Code Quality report whose findings are posted on the merge request.
+type Review struct {
+	FilePath string `json:"file"`
+	Format   string `json:"format"`
+}
+
+// Read returns the findings of the report found in folder, or nil when no review is configured.
+func (review *Review) Read(folder string) ([]findings.Finding, error) {
+	if review == nil {
+		return nil, nil
+	}
+
+	content, err := os.ReadFile(path.Join(folder, review.FilePath))
+	if err != nil {
+		return nil, err
+	}
+
+	return findings.Parse(content, review.Format)
+}
+
+// Render reads the comment content and, when it is a template, executes it with the given variables along with
+// the content of the file (as FileContent) and of the named files (as Files). The text is the template if set,
+// the file otherwise. Referencing a missing variable or file is an error.
+func (comment Comment) Render(folder string, variables map[string]interface{}) (string, error) {
+	if !comment.Template {
+		return comment.ReadContent(folder)
+	}
+
+	data := map[string]interface{}{}
+	for name, value := range variables {
+		data[name] = value
+	}
+
+	content := comment.Text
+	if comment.FilePath != "" {
+		fileContent, err := os.ReadFile(path.Join(folder, comment.FilePath))
+		if err != nil {
+			return "", err
+		}
+		data["FileContent"] = string(fileContent)
+		if content == "" {
+			content = string(fileContent)
+		}
+	}
+
+	files := make(map[string]interface{})
+	for name, file := range comment.Files {
+		fileContent, err := os.ReadFile(path.Join(folder, file))
+		if err != nil {
+			return "", err
+		}
+		files[name] = string(fileContent)
+	}
+	data["Files"] = files
+
+	return renderTemplate("comment", content, data)
+}
+
+// renderTemplate executes the named template text with the given data, referencing a missing variable is an error.
+func renderTemplate(name string, text string, data map[string]interface{}) (string, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("parsing %s template: %w", name, err)
+	}
+
+	var result strings.Builder
+	err = tmpl.Execute(&result, data)
+	if err != nil {
+		return "", fmt.Errorf("rendering %s template: %w", name, err)
+	}
+
+	return result.String(), nil
+}
+
+// Merge configures the acceptance of the merge request. The commit messages are templates rendered with the
+// same variables as the comment.
+type Merge struct {
+	Squash                    *bool  `json:"squash"`
+	ShouldRemoveSourceBranch  *bool  `json:"should_remove_source_branch"`
+	MergeWhenPipelineSucceeds *bool  `json:"merge_when_pipeline_succeeds"`
+	CommitMessage             string `json:"commit_message"`
+	SquashCommitMessage       string `json:"squash_commit_message"`
+	VerifySHA                 *bool  `json:"verify_sha"`
+}
+
+// WhenPipelineSucceeds tells whether the merge is scheduled for when the pipeline succeeds instead of right away.
+func (merge Merge) WhenPipelineSucceeds() bool {
+	return merge.MergeWhenPipelineSucceeds != nil && *merge.MergeWhenPipelineSucceeds
+}
+
+// GetVerifySHA tells whether the merge must be refused if the merge request head is not the fetched commit,
+// which is the default.
+func (merge Merge) GetVerifySHA() bool {
+	return merge.VerifySHA == nil || *merge.VerifySHA
+}
+
+// Update configures changes to the fields of the merge request. Assignees and reviewers are given by username
+// and the milestone by title, an empty title removing it.
+type Update struct {
+	Assignees    []string `json:"assignees"`
+	AddAssignees []string `json:"add_assignees"`
+	Reviewers    []string `json:"reviewers"`
+	AddReviewers []string `json:"add_reviewers"`
+	Milestone    *string  `json:"milestone"`
+	Draft        *bool    `json:"draft"`
+	State        string   `json:"state"`
+	TitlePrefix  string   `json:"title_prefix"`
+}
+
+// Update states, closing or reopening the merge request.
+const (
+	UpdateStateClose  = "close"
+	UpdateStateReopen = "reopen"
+)
+
+func (update *Update) Validate() error {
+	if update == nil {
+		return nil
+	}
+
+	switch update.State {
+	case "", UpdateStateClose, UpdateStateReopen:
+	default:
+		return fmt.Errorf("invalid value for update state: %v", update.State)
+	}
+
+	if update.Assignees != nil && update.AddAssignees != nil {
+		return fmt.Errorf("update assignees and add_assignees are mutually exclusive")
+	}
+
+	if update.Reviewers != nil && update.AddReviewers != nil {
+		return fmt.Errorf("update reviewers and add_reviewers are mutually exclusive")
+	}
+
+	return nil
+}
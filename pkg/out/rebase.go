@@ -0,0 +1,61 @@
+package out
+
+import (
+	"fmt"
+	"github.com/xanzy/go-gitlab"
+	"time"
+)
+
+// rebaseMergeRequest rebases the source branch onto the target branch and waits for gitlab to finish. The
+// returned merge request has the new head sha. When the rebased head is to be merged, the head must still be the
+// tested commit before the rebase.
+func (command *Command) rebaseMergeRequest(request Request, mr gitlab.MergeRequest) (gitlab.MergeRequest, error) {
+	if !request.Params.Rebase {
+		return mr, nil
+	}
+
+	timeout, err := request.Params.GetRebaseTimeout()
+	if err != nil {
+		return mr, err
+	}
+
+	if request.Params.Merge != nil && request.Params.Merge.GetVerifySHA() {
+		err = command.verifySHA(mr)
+		if err != nil {
+			return mr, err
+		}
+	}
+
+	_, err = command.client.MergeRequests.RebaseMergeRequest(mr.ProjectID, mr.IID)
+	if err != nil {
+		return mr, err
+	}
+	command.logger.Info("rebasing merge request", "iid", mr.IID, "timeout", timeout)
+
+	include := true
+	options := gitlab.GetMergeRequestsOptions{IncludeRebaseInProgress: &include}
+	deadline := time.Now().Add(timeout)
+
+	for {
+		current, _, err := command.client.MergeRequests.GetMergeRequest(mr.ProjectID, mr.IID, &options)
+		if err != nil {
+			return mr, err
+		}
+
+		if !current.RebaseInProgress {
+			if current.MergeError != "" {
+				return mr, fmt.Errorf("rebase of merge request !%d failed: %s", mr.IID, current.MergeError)
+			}
+			command.logger.Info("rebased merge request", "iid", mr.IID, "sha", current.SHA)
+			mr.SHA = current.SHA
+			return mr, nil
+		}
+
+		if time.Now().After(deadline) {
+			return mr, fmt.Errorf("rebase of merge request !%d still in progress after %v", mr.IID, timeout)
+		}
+
+		command.logger.Debug("rebase in progress", "iid", mr.IID)
+		time.Sleep(command.pollInterval)
+	}
+}
@@ -0,0 +1,106 @@
+package out
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/xanzy/go-gitlab"
+	"os"
+	"path/filepath"
+)
+
+// getMergeRequest returns the merge request identified by the params, or the one fetched by the get step into
+// the repository otherwise.
+func (command *Command) getMergeRequest(destination string, request Request) (gitlab.MergeRequest, error) {
+	params := request.Params
+	if params.IID == 0 && params.SourceBranch == "" && params.SHA == "" {
+		return readMergeRequest(destination, params.Repository)
+	}
+
+	iid, err := command.resolveIID(request)
+	if err != nil {
+		return gitlab.MergeRequest{}, err
+	}
+
+	mr, _, err := command.client.MergeRequests.GetMergeRequest(request.Source.GetProjectPath(), iid, &gitlab.GetMergeRequestsOptions{})
+	if err != nil {
+		return gitlab.MergeRequest{}, err
+	}
+
+	// the status is set on the tested commit, which may not be the head of the merge request anymore
+	if params.SHA != "" {
+		mr.SHA = params.SHA
+	}
+
+	return *mr, nil
+}
+
+// resolveIID returns the iid given by the params, or of the only opened merge request of the source branch or
+// containing the commit.
+func (command *Command) resolveIID(request Request) (int, error) {
+	params := request.Params
+	project := request.Source.GetProjectPath()
+
+	var (
+		requests    []*gitlab.MergeRequest
+		description string
+		err         error
+	)
+	switch {
+	case params.IID != 0:
+		return params.IID, nil
+	case params.SourceBranch != "":
+		description = "source branch " + params.SourceBranch
+		options := &gitlab.ListProjectMergeRequestsOptions{
+			State:        gitlab.String("opened"),
+			SourceBranch: gitlab.String(params.SourceBranch),
+		}
+		if request.Source.TargetBranch != "" {
+			options.TargetBranch = gitlab.String(request.Source.TargetBranch)
+		}
+		requests, _, err = command.client.MergeRequests.ListProjectMergeRequests(project, options)
+	default:
+		description = "commit " + params.SHA
+		var all []*gitlab.MergeRequest
+		all, _, err = command.client.Commits.ListMergeRequestsByCommit(project, params.SHA)
+		for _, mr := range all {
+			if mr.State == "opened" {
+				requests = append(requests, mr)
+			}
+		}
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	switch len(requests) {
+	case 0:
+		return 0, fmt.Errorf("no opened merge request found for %s", description)
+	case 1:
+		return requests[0].IID, nil
+	}
+	return 0, fmt.Errorf("%d opened merge requests found for %s, use iid instead", len(requests), description)
+}
+
+// readMergeRequest reads the merge request written by the get step in the repository.
+func readMergeRequest(destination string, repository string) (gitlab.MergeRequest, error) {
+	var mr gitlab.MergeRequest
+
+	repo := filepath.Join(destination, repository)
+	err := os.MkdirAll(repo, 0755)
+	if err != nil {
+		return mr, err
+	}
+
+	err = os.Chdir(repo)
+	if err != nil {
+		return mr, err
+	}
+
+	file, err := os.ReadFile(".git/merge-request.json")
+	if err != nil {
+		return mr, err
+	}
+
+	err = json.Unmarshal(file, &mr)
+	return mr, err
+}
@@ -0,0 +1,183 @@
+package out
+
+import (
+	"fmt"
+	"github.com/samcontesse/gitlab-merge-request-resource/pkg"
+	"github.com/samcontesse/gitlab-merge-request-resource/pkg/findings"
+	"github.com/xanzy/go-gitlab"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	findingMarkerPrefix = "<!-- gitlab-merge-request-resource:finding:"
+	reviewSummaryMarker = "<!-- gitlab-merge-request-resource:review -->"
+)
+
+var (
+	findingMarker = regexp.MustCompile(`<!-- gitlab-merge-request-resource:finding:(\S+) -->`)
+	hunkHeader    = regexp.MustCompile(`^@@ -\d+(?:,\d+)? \+(\d+)(?:,\d+)? @@`)
+)
+
+// postReview starts a diff discussion for each finding on a line added by the merge request, and summarises the
+// other findings in a single note updated in place. Findings posted by a previous put are skipped.
+func (command *Command) postReview(mr gitlab.MergeRequest, results []findings.Finding) error {
+	if len(results) == 0 {
+		return nil
+	}
+
+	version, err := pkg.GetLatestDiffVersion(command.client, &mr)
+	if err != nil {
+		return err
+	}
+
+	var (
+		added    map[string]map[int]bool
+		oldPaths map[string]string
+	)
+	if version != nil {
+		added, oldPaths = addedLines(version.Diffs)
+	}
+
+	posted, err := command.postedFindings(mr)
+	if err != nil {
+		return err
+	}
+
+	var unchanged []findings.Finding
+	created := 0
+	for _, finding := range results {
+		if !added[finding.Path][finding.Line] {
+			unchanged = append(unchanged, finding)
+			continue
+		}
+		if posted[finding.Fingerprint] {
+			continue
+		}
+
+		body := fmt.Sprintf("%s%s -->\n%s", findingMarkerPrefix, finding.Fingerprint, formatFinding(finding))
+		options := gitlab.CreateMergeRequestDiscussionOptions{
+			Body: &body,
+			Position: &gitlab.NotePosition{
+				BaseSHA:      version.BaseCommitSHA,
+				StartSHA:     version.StartCommitSHA,
+				HeadSHA:      version.HeadCommitSHA,
+				PositionType: "text",
+				OldPath:      oldPaths[finding.Path],
+				NewPath:      finding.Path,
+				NewLine:      finding.Line,
+			},
+		}
+		_, _, err = command.client.Discussions.CreateMergeRequestDiscussion(mr.ProjectID, mr.IID, &options)
+		if err != nil {
+			return fmt.Errorf("posting finding on %s:%d: %w", finding.Path, finding.Line, err)
+		}
+		created++
+	}
+	command.logger.Info("posted review", "iid", mr.IID, "findings", len(results), "discussions", created, "outside_changes", len(unchanged))
+
+	return command.updateReviewSummary(mr, unchanged)
+}
+
+// updateReviewSummary edits the note listing the findings outside of the changes, or creates it.
+func (command *Command) updateReviewSummary(mr gitlab.MergeRequest, unchanged []findings.Finding) error {
+	note, err := command.findStickyNote(mr, reviewSummaryMarker)
+	if err != nil {
+		return err
+	}
+
+	if len(unchanged) == 0 && note == nil {
+		return nil
+	}
+
+	var body strings.Builder
+	body.WriteString(reviewSummaryMarker + "\n")
+	if len(unchanged) == 0 {
+		body.WriteString("No findings outside of the changes.\n")
+	} else {
+		fmt.Fprintf(&body, "Findings outside of the changes (%d):\n\n", len(unchanged))
+		body.WriteString("| Location | Severity | Rule | Message |\n|---|---|---|---|\n")
+		for _, finding := range unchanged {
+			fmt.Fprintf(&body, "| `%s:%d` | %s | %s | %s |\n", finding.Path, finding.Line, finding.Severity,
+				escapeCell(finding.Rule), escapeCell(finding.Message))
+		}
+	}
+
+	content := body.String()
+	if note == nil {
+		options := gitlab.CreateMergeRequestNoteOptions{Body: &content}
+		_, _, err = command.client.Notes.CreateMergeRequestNote(mr.ProjectID, mr.IID, &options)
+		return err
+	}
+
+	if note.Body == content {
+		return nil
+	}
+
+	options := gitlab.UpdateMergeRequestNoteOptions{Body: &content}
+	_, _, err = command.client.Notes.UpdateMergeRequestNote(mr.ProjectID, mr.IID, note.ID, &options)
+	return err
+}
+
+// postedFindings returns the fingerprints of the findings already posted on the merge request.
+func (command *Command) postedFindings(mr gitlab.MergeRequest) (map[string]bool, error) {
+	posted := make(map[string]bool)
+	options := &gitlab.ListMergeRequestNotesOptions{ListOptions: gitlab.ListOptions{PerPage: 100}}
+	for {
+		notes, response, err := command.client.Notes.ListMergeRequestNotes(mr.ProjectID, mr.IID, options)
+		if err != nil {
+			return nil, err
+		}
+		for _, note := range notes {
+			if match := findingMarker.FindStringSubmatch(note.Body); match != nil {
+				posted[match[1]] = true
+			}
+		}
+		if response.NextPage == 0 {
+			return posted, nil
+		}
+		options.Page = response.NextPage
+	}
+}
+
+// addedLines returns the new line numbers added by the diffs and the path of the files before the diffs, by file
+// path.
+func addedLines(diffs []*gitlab.Diff) (map[string]map[int]bool, map[string]string) {
+	added := make(map[string]map[int]bool)
+	oldPaths := make(map[string]string)
+	for _, diff := range diffs {
+		if diff.DeletedFile {
+			continue
+		}
+		lines := make(map[int]bool)
+		line := 0
+		for _, text := range strings.Split(diff.Diff, "\n") {
+			if match := hunkHeader.FindStringSubmatch(text); match != nil {
+				line, _ = strconv.Atoi(match[1])
+				continue
+			}
+			switch {
+			case strings.HasPrefix(text, "+"):
+				lines[line] = true
+				line++
+			case strings.HasPrefix(text, " "):
+				line++
+			}
+		}
+		added[diff.NewPath] = lines
+		oldPaths[diff.NewPath] = diff.OldPath
+	}
+	return added, oldPaths
+}
+
+func formatFinding(finding findings.Finding) string {
+	if finding.Rule == "" {
+		return fmt.Sprintf("**%s**: %s", finding.Severity, finding.Message)
+	}
+	return fmt.Sprintf("**%s** `%s`: %s", finding.Severity, finding.Rule, finding.Message)
+}
+
+func escapeCell(value string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(value, "|", "\\|"), "\n", " ")
+}
@@ -0,0 +1,91 @@
+package out
+
+import (
+	"fmt"
+	"github.com/xanzy/go-gitlab"
+	"strings"
+)
+
+// updateStickyNote edits the note previously created by the resource, identified by a hidden marker, or creates it.
+// On success, the note is deleted or collapsed when the comment asks for it.
+func (command *Command) updateStickyNote(request Request, mr gitlab.MergeRequest, body string) error {
+	marker := stickyMarker(request)
+
+	note, err := command.findStickyNote(mr, marker)
+	if err != nil {
+		return err
+	}
+
+	if request.Params.Status == "success" {
+		switch request.Params.Comment.OnSuccess {
+		case CommentOnSuccessDelete:
+			if note != nil {
+				_, err = command.client.Notes.DeleteMergeRequestNote(mr.ProjectID, mr.IID, note.ID)
+			}
+			return err
+		case CommentOnSuccessCollapse:
+			if body == "" && note != nil {
+				body = strings.TrimSpace(strings.Replace(note.Body, marker, "", 1))
+				body = strings.TrimSuffix(strings.TrimPrefix(body, collapsedPrefix), collapsedSuffix)
+			}
+			if body != "" {
+				body = collapsedPrefix + body + collapsedSuffix
+			}
+		}
+	}
+
+	if body == "" {
+		return nil
+	}
+
+	body = marker + "\n" + body
+
+	if note == nil {
+		options := gitlab.CreateMergeRequestNoteOptions{Body: &body}
+		_, _, err = command.client.Notes.CreateMergeRequestNote(mr.ProjectID, mr.IID, &options)
+		return err
+	}
+
+	options := gitlab.UpdateMergeRequestNoteOptions{Body: &body}
+	_, _, err = command.client.Notes.UpdateMergeRequestNote(mr.ProjectID, mr.IID, note.ID, &options)
+	return err
+}
+
+const (
+	collapsedPrefix = "<details><summary>Resolved</summary>\n\n"
+	collapsedSuffix = "\n</details>"
+)
+
+// stickyMarker returns the hidden html comment identifying the sticky note, scoped to the status context if asked.
+func stickyMarker(request Request) string {
+	if request.Params.Comment.PerContext {
+		return fmt.Sprintf("<!-- gitlab-merge-request-resource:sticky:%s -->", request.Params.GetStatusName(request.Source))
+	}
+	return "<!-- gitlab-merge-request-resource:sticky -->"
+}
+
+// findStickyNote returns the note of the token user starting with the marker, the notes of other users quoting it
+// being ignored since they cannot be edited.
+func (command *Command) findStickyNote(mr gitlab.MergeRequest, marker string) (*gitlab.Note, error) {
+	user, _, err := command.client.Users.CurrentUser()
+	if err != nil {
+		return nil, err
+	}
+
+	options := &gitlab.ListMergeRequestNotesOptions{ListOptions: gitlab.ListOptions{PerPage: 100}}
+	for {
+		notes, response, err := command.client.Notes.ListMergeRequestNotes(mr.ProjectID, mr.IID, options)
+		if err != nil {
+			return nil, err
+		}
+		for _, note := range notes {
+			if note.Author.ID == user.ID && strings.HasPrefix(note.Body, marker) {
+				return note, nil
+			}
+		}
+		if response.NextPage == 0 {
+			return nil, nil
+		}
+		options.Page = response.NextPage
+	}
+}
@@ -0,0 +1,43 @@
+package out
+
+import (
+	"fmt"
+	"github.com/samcontesse/gitlab-merge-request-resource/pkg/junit"
+	"os"
+	"path/filepath"
+)
+
+// failedTestsLimit is the number of failed tests listed in the summary comment.
+const failedTestsLimit = 10
+
+// readTestReports merges the junit reports matching the glob patterns relative to folder. It returns nil when
+// no pattern is given, and fails when a pattern matches no file.
+func readTestReports(folder string, patterns []string) (*junit.Report, error) {
+	if len(patterns) == 0 {
+		return nil, nil
+	}
+
+	report := &junit.Report{}
+	for _, pattern := range patterns {
+		files, err := filepath.Glob(filepath.Join(folder, pattern))
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for test_reports: %v", err)
+		}
+		if len(files) == 0 {
+			return nil, fmt.Errorf("no test report matches %s", pattern)
+		}
+		for _, file := range files {
+			content, err := os.ReadFile(file)
+			if err != nil {
+				return nil, err
+			}
+			parsed, err := junit.Parse(content)
+			if err != nil {
+				return nil, fmt.Errorf("parsing test report %s: %w", file, err)
+			}
+			report.Merge(parsed)
+		}
+	}
+
+	return report, nil
+}
@@ -0,0 +1,180 @@
+package out
+
+import (
+	"fmt"
+	"github.com/xanzy/go-gitlab"
+	"regexp"
+	"strings"
+)
+
+// draftPrefix matches the title prefixes gitlab recognizes as draft markers.
+var draftPrefix = regexp.MustCompile(`(?i)^\s*(\[draft\]|\(draft\)|draft:|\[wip\]|wip:)\s*`)
+
+// updateOptions resolves the usernames and the milestone of the update params into the options of the merge
+// request update, so that an unknown name fails before anything is changed. It returns nil without update or when
+// nothing would change, gitlab rejecting an update without any attribute.
+func (command *Command) updateOptions(request Request, mr gitlab.MergeRequest) (*gitlab.UpdateMergeRequestOptions, error) {
+	update := request.Params.Update
+	if update == nil {
+		return nil, nil
+	}
+
+	options := gitlab.UpdateMergeRequestOptions{}
+
+	if update.Assignees != nil || update.AddAssignees != nil {
+		ids, err := command.userIDs(mr.Assignees, update.Assignees, update.AddAssignees)
+		if err != nil {
+			return nil, err
+		}
+		options.AssigneeIDs = &ids
+	}
+
+	if update.Reviewers != nil || update.AddReviewers != nil {
+		ids, err := command.userIDs(mr.Reviewers, update.Reviewers, update.AddReviewers)
+		if err != nil {
+			return nil, err
+		}
+		options.ReviewerIDs = &ids
+	}
+
+	if update.Milestone != nil {
+		id, err := command.milestoneID(mr, *update.Milestone)
+		if err != nil {
+			return nil, err
+		}
+		options.MilestoneID = &id
+	}
+
+	switch update.State {
+	case UpdateStateClose, UpdateStateReopen:
+		options.StateEvent = &update.State
+	}
+
+	if update.Draft != nil || update.TitlePrefix != "" {
+		title := updateTitle(mr.Title, update.TitlePrefix, update.Draft)
+		if title != mr.Title {
+			options.Title = &title
+		}
+	}
+
+	if options == (gitlab.UpdateMergeRequestOptions{}) {
+		return nil, nil
+	}
+
+	return &options, nil
+}
+
+// updateTitle prefixes the title unless it already is, and adds or removes the draft marker if asked. An existing
+// draft marker is kept as is.
+func updateTitle(title string, prefix string, draft *bool) string {
+	marker := draftPrefix.FindString(title)
+	title = title[len(marker):]
+
+	if prefix != "" && !strings.HasPrefix(title, prefix) {
+		title = prefix + title
+	}
+
+	if draft != nil {
+		if !*draft {
+			marker = ""
+		} else if marker == "" {
+			marker = "Draft: "
+		}
+	}
+
+	return marker + title
+}
+
+// userIDs returns the ids of the given usernames, or of the current users followed by the added usernames.
+func (command *Command) userIDs(current []*gitlab.BasicUser, set []string, add []string) ([]int, error) {
+	ids := make([]int, 0)
+	if set == nil {
+		for _, user := range current {
+			ids = append(ids, user.ID)
+		}
+	}
+
+	for _, username := range append(set, add...) {
+		username := strings.TrimPrefix(username, "@")
+		users, _, err := command.client.Users.ListUsers(&gitlab.ListUsersOptions{Username: &username})
+		if err != nil {
+			return nil, err
+		}
+		if len(users) == 0 {
+			return nil, fmt.Errorf("user %s not found", username)
+		}
+		if !containsID(ids, users[0].ID) {
+			ids = append(ids, users[0].ID)
+		}
+	}
+
+	return ids, nil
+}
+
+func containsID(ids []int, id int) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
+
+// milestoneID returns the id of the milestone of the project, or of its groups, with the given title.
+// An empty title returns 0 which removes the milestone.
+func (command *Command) milestoneID(mr gitlab.MergeRequest, title string) (int, error) {
+	if title == "" {
+		return 0, nil
+	}
+
+	include := true
+	options := gitlab.ListMilestonesOptions{Title: &title, IncludeParentMilestones: &include}
+	milestones, _, err := command.client.Milestones.ListMilestones(mr.ProjectID, &options)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, milestone := range milestones {
+		if milestone.Title == title {
+			return milestone.ID, nil
+		}
+	}
+
+	return 0, fmt.Errorf("milestone %s not found", title)
+}
+
+// updateMergeRequest applies the update options and returns the merge request with the updated fields.
+// In dry run, only the title and the state are known.
+func (command *Command) updateMergeRequest(request Request, mr gitlab.MergeRequest, options *gitlab.UpdateMergeRequestOptions) (gitlab.MergeRequest, error) {
+	if options == nil {
+		return mr, nil
+	}
+
+	result, _, err := command.client.MergeRequests.UpdateMergeRequest(mr.ProjectID, mr.IID, options)
+	if err != nil {
+		return mr, err
+	}
+	command.logger.Info("updated merge request", "iid", mr.IID)
+
+	if request.DryRun() {
+		if options.Title != nil {
+			mr.Title = *options.Title
+		}
+		switch request.Params.Update.State {
+		case UpdateStateClose:
+			mr.State = "closed"
+		case UpdateStateReopen:
+			mr.State = "opened"
+		}
+		return mr, nil
+	}
+
+	mr.Title = result.Title
+	mr.State = result.State
+	mr.Draft = result.Draft
+	mr.WorkInProgress = result.WorkInProgress
+	mr.Assignees = result.Assignees
+	mr.Reviewers = result.Reviewers
+	mr.Milestone = result.Milestone
+	return mr, nil
+}